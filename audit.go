@@ -0,0 +1,210 @@
+package gigago
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single record of the audit log written by a client configured
+// with WithAuditLog. The log is a stream of JSON objects, one per line.
+type AuditEntry struct {
+	// Time is the moment the call started.
+	Time time.Time `json:"time"`
+
+	// Method and Endpoint identify the call.
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+
+	// Status is the HTTP status code of the last response, 0 if none was received.
+	Status int `json:"status,omitempty"`
+
+	// Duration is the time the call took, including retries.
+	Duration time.Duration `json:"duration"`
+
+	// Request is the (redacted) request body.
+	Request json.RawMessage `json:"request,omitempty"`
+
+	// RequestHash is the hex-encoded SHA-256 hash of the canonical form of the
+	// request body before redaction (see CanonicalJSON), which identifies
+	// identical requests. It is empty for requests without a JSON body.
+	RequestHash string `json:"request_hash,omitempty"`
+
+	// Response is the (redacted) response body. Bodies that are not valid JSON
+	// are stored as a JSON string. For streamed calls, it is the completion
+	// assembled from the received chunks.
+	Response json.RawMessage `json:"response,omitempty"`
+
+	// Streamed reports whether the response was streamed.
+	Streamed bool `json:"streamed,omitempty"`
+
+	// Error is the error message of a failed call.
+	Error string `json:"error,omitempty"`
+}
+
+// RedactionRule masks sensitive data in request and response bodies before
+// they are written to the audit log.
+type RedactionRule struct {
+	// Path selects the JSON values the rule applies to, as dot-separated object
+	// keys, where "*" matches any key or array element, e.g. "messages.*.content".
+	// An empty path selects every string value in the body.
+	Path string
+
+	// Pattern selects the spans to mask within the selected string values.
+	// If nil, selected values are masked entirely, whatever their type.
+	Pattern *regexp.Regexp
+
+	// Replacement is written instead of the masked data. Defaults to "[REDACTED]".
+	Replacement string
+}
+
+const defaultRedactionReplacement = "[REDACTED]"
+
+// auditLog writes audit entries to a writer. It is safe for concurrent use.
+type auditLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	rules []RedactionRule
+}
+
+// WithAuditLog provides an Option to write a record of every API call,
+// including request and response bodies, to w as JSON lines (see AuditEntry).
+// Bodies may contain personal data; use WithRedaction to mask it.
+// OAuth requests, which carry credentials, are never logged.
+func WithAuditLog(w io.Writer) Option {
+	return func(c *Client) {
+		if c.auditLog == nil {
+			c.auditLog = &auditLog{}
+		}
+		c.auditLog.w = w
+	}
+}
+
+// WithRedaction provides an Option to add rules masking sensitive data in the
+// audit log. Rules are applied in order.
+func WithRedaction(rules ...RedactionRule) Option {
+	return func(c *Client) {
+		if c.auditLog == nil {
+			c.auditLog = &auditLog{}
+		}
+		c.auditLog.rules = append(c.auditLog.rules, rules...)
+	}
+}
+
+// audit writes an entry for a completed call if the audit log is enabled.
+// Request bodies that are not JSON are replaced by a placeholder.
+func (c *Client) audit(start time.Time, method, endpoint, contentType string, elapsed time.Duration, reqBody []byte, res callResult, err error) {
+	l := c.auditLog
+	if l == nil || l.w == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:     start,
+		Method:   method,
+		Endpoint: endpoint,
+		Status:   res.status,
+		Duration: elapsed,
+		Response: l.redact(res.body),
+		Streamed: res.streamed,
+	}
+	if reqBody != nil && contentType != contentTypeJSON {
+		reqBody = []byte(fmt.Sprintf(`"<%s body, %d bytes>"`, contentType, len(reqBody)))
+	} else if reqBody != nil {
+		hash := requestHash(reqBody)
+		entry.RequestHash = hex.EncodeToString(hash[:])
+	}
+	entry.Request = l.redact(reqBody)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	line, mErr := json.Marshal(entry)
+	if mErr != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// redact applies the redaction rules to a body. Bodies that are not valid
+// JSON are converted to a JSON string first.
+func (l *auditLog) redact(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	// Numbers are kept as json.Number, as float64 would round large integers
+	// such as seeds and IDs.
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.Decode(new(any)) != io.EOF {
+		v = string(body)
+	}
+
+	for _, rule := range l.rules {
+		var path []string
+		if rule.Path != "" {
+			path = strings.Split(rule.Path, ".")
+		}
+		v = rule.apply(v, path)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// apply returns v with the rule applied to the values selected by path.
+func (r RedactionRule) apply(v any, path []string) any {
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = defaultRedactionReplacement
+	}
+
+	if r.Path != "" && len(path) == 0 {
+		if s, ok := v.(string); ok && r.Pattern != nil {
+			return r.Pattern.ReplaceAllLiteralString(s, replacement)
+		}
+		return replacement
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if r.Path == "" {
+				val[k] = r.apply(child, nil)
+			} else if path[0] == "*" || path[0] == k {
+				val[k] = r.apply(child, path[1:])
+			}
+		}
+	case []any:
+		for i, child := range val {
+			if r.Path == "" {
+				val[i] = r.apply(child, nil)
+			} else if path[0] == "*" {
+				val[i] = r.apply(child, path[1:])
+			}
+		}
+	case string:
+		if r.Path == "" {
+			if r.Pattern == nil {
+				return replacement
+			}
+			return r.Pattern.ReplaceAllLiteralString(val, replacement)
+		}
+	}
+	return v
+}
@@ -0,0 +1,173 @@
+package gigago
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultKeyCooldown is how long a key is avoided after a 429 response without Retry-After.
+const defaultKeyCooldown = time.Minute
+
+// WeightedKey is an authorization key balanced by WithKeyBalancing.
+type WeightedKey struct {
+	// APIKey is the authorization key.
+	APIKey string
+
+	// Scope is the scope of the key. Defaults to the client's scope.
+	Scope string
+
+	// Weight is the relative share of the requests sent with the key. Defaults to 1.
+	Weight int
+
+	// Quota, if positive, is the number of tokens the key may spend. The share of
+	// the key decreases as its usage approaches the quota and is zero once the
+	// quota is spent. Usage is counted since the client was created or
+	// ResetUsage was called.
+	Quota int64
+}
+
+// WithKeyBalancing provides an Option to spread requests over several GigaChat
+// accounts by weight. Keys that received a 429 response are avoided for the
+// time given by its Retry-After header (one minute by default), and keys with
+// a quota are used less as it is spent. If all keys are throttled or out of
+// quota, the available key with the least usage is used, or the key that
+// becomes available the soonest.
+//
+// The client's own key is used for requests made with ContextWithCredentials
+// only. Access tokens of the keys are fetched on demand and cached.
+func WithKeyBalancing(keys ...WeightedKey) Option {
+	return func(c *Client) {
+		b := &keyBalancer{keys: make([]*balancedKey, len(keys))}
+		for i, k := range keys {
+			if k.Weight <= 0 {
+				k.Weight = 1
+			}
+			b.keys[i] = &balancedKey{WeightedKey: k}
+		}
+		c.balancer = b
+	}
+}
+
+// keyBalancer selects keys for WithKeyBalancing. It is safe for concurrent use.
+type keyBalancer struct {
+	mu   sync.Mutex
+	keys []*balancedKey
+}
+
+// balancedKey is a key with its health and usage.
+type balancedKey struct {
+	WeightedKey
+	used          int64
+	cooldownUntil time.Time
+}
+
+// weight returns the effective weight of the key at now.
+func (k *balancedKey) weight(now time.Time) float64 {
+	if now.Before(k.cooldownUntil) {
+		return 0
+	}
+	w := float64(k.Weight)
+	if k.Quota > 0 {
+		w *= max(0, 1-float64(k.used)/float64(k.Quota))
+	}
+	return w
+}
+
+// pick selects a key by effective weight, using r in [0, 1) as the random number.
+func (b *keyBalancer) pick(now time.Time, r float64) *balancedKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var total float64
+	for _, k := range b.keys {
+		total += k.weight(now)
+	}
+	if total == 0 {
+		return b.fallback(now)
+	}
+
+	target := r * total
+	for _, k := range b.keys {
+		w := k.weight(now)
+		if target < w {
+			return k
+		}
+		target -= w
+	}
+	return b.keys[len(b.keys)-1]
+}
+
+// fallback selects a key when no key has a positive weight: the available key
+// with the least usage, or the key available the soonest.
+func (b *keyBalancer) fallback(now time.Time) *balancedKey {
+	var best *balancedKey
+	for _, k := range b.keys {
+		switch {
+		case best == nil:
+			best = k
+		case now.Before(best.cooldownUntil) || now.Before(k.cooldownUntil):
+			if k.cooldownUntil.Before(best.cooldownUntil) {
+				best = k
+			}
+		case k.used < best.used:
+			best = k
+		}
+	}
+	return best
+}
+
+// report records the outcome of a request made with key.
+func (b *keyBalancer) report(key *balancedKey, now time.Time, status int, header http.Header, tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key.used += int64(tokens)
+	if status == http.StatusTooManyRequests {
+		cooldown := defaultKeyCooldown
+		if delay := retryAfterDelay(header, now); delay > 0 {
+			cooldown = delay
+		}
+		key.cooldownUntil = now.Add(cooldown)
+	}
+}
+
+func (b *keyBalancer) resetUsage() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range b.keys {
+		k.used = 0
+	}
+}
+
+// balancedContext returns ctx with the credentials of a key selected by the
+// balancer, unless balancing is disabled or ctx already carries credentials.
+func (c *Client) balancedContext(ctx context.Context) (context.Context, *balancedKey) {
+	if c.balancer == nil || len(c.balancer.keys) == 0 {
+		return ctx, nil
+	}
+	if creds, ok := c.credentialsFromContext(ctx); ok {
+		// The key pinned by pinnedContext, unless the caller set other credentials.
+		if key, ok := ctx.Value(pinnedKeyKey{}).(*balancedKey); ok && creds.apiKey == key.APIKey {
+			return ctx, key
+		}
+		return ctx, nil
+	}
+	key := c.balancer.pick(time.Now(), c.randFloat64())
+	return ContextWithCredentials(ctx, key.APIKey, key.Scope), key
+}
+
+type pinnedKeyKey struct{}
+
+// pinnedContext returns ctx with a key selected by the balancer pinned for all
+// the requests made with it, for flows whose requests must be made by the same
+// account, e.g. uploading a file and referring to it. Usage is still reported
+// to the balancer per request.
+func (c *Client) pinnedContext(ctx context.Context) context.Context {
+	ctx, key := c.balancedContext(ctx)
+	if key == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pinnedKeyKey{}, key)
+}
@@ -0,0 +1,49 @@
+package gigago
+
+// CallOption configures a single call to Generate, overriding the settings of
+// the GenerativeModel for that call only.
+type CallOption func(*callOptions)
+
+// callOptions holds the settings collected from CallOptions.
+type callOptions struct {
+	seed           *int64
+	prefill        string
+	repairAttempts *int
+	latency        *LatencyBudget
+}
+
+func newCallOptions(opts []CallOption) *callOptions {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSeed provides a CallOption to set the sampling seed, making the output
+// reproducible (if supported by the API) regardless of the model's Seed.
+func WithSeed(seed int64) CallOption {
+	return func(o *callOptions) {
+		o.seed = &seed
+	}
+}
+
+// WithPrefill provides a CallOption to start the assistant's answer with text,
+// which the model must continue, e.g. "{" to force a JSON object.
+// The prefill is sent as the last message of the conversation, so the
+// conversation itself must not end with an assistant message. The returned
+// content includes the prefill.
+func WithPrefill(text string) CallOption {
+	return func(o *callOptions) {
+		o.prefill = text
+	}
+}
+
+// WithRepairAttempts provides a CallOption to set how many times GenerateInto
+// asks the model to fix an answer that does not decode, 2 by default. Zero
+// disables repairs.
+func WithRepairAttempts(n int) CallOption {
+	return func(o *callOptions) {
+		o.repairAttempts = &n
+	}
+}
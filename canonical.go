@@ -0,0 +1,89 @@
+package gigago
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// CanonicalJSON returns the canonical form of a JSON request body: object keys
+// are sorted, insignificant whitespace is removed, and in the content of
+// messages line endings are normalized to "\n", trailing whitespace of lines
+// is removed and leading and trailing blank space is trimmed. Logically
+// identical requests have identical canonical forms, which makes them suitable
+// for cache keys, idempotency keys and hashing.
+func CanonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if body, ok := v.(map[string]any); ok {
+		if messages, ok := body["messages"].([]any); ok {
+			for _, m := range messages {
+				if msg, ok := m.(map[string]any); ok {
+					if content, ok := msg["content"].(string); ok {
+						msg["content"] = normalizeContent(content)
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// RequestKey returns a key identifying the request Generate would send for the
+// messages: the hex-encoded SHA-256 hash of its canonical form (see CanonicalJSON).
+// Model substitutions made by WithModelUpgrades are not taken into account.
+func (g *GenerativeModel) RequestKey(messages []Message, opts ...CallOption) (string, error) {
+	payload, err := g.newPayload(messages, newCallOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := requestHash(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// MarshalRequestForTest returns the exact JSON body Generate would send for
+// the messages, after validation and with all model settings and call options
+// applied, but without sending it or running input moderation. It is meant for
+// golden-file tests that catch unintended changes to the wire format.
+func (g *GenerativeModel) MarshalRequestForTest(messages []Message, opts ...CallOption) ([]byte, error) {
+	payload, err := g.buildPayload(messages, newCallOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// requestHash returns the SHA-256 hash of the canonical form of a JSON request
+// body, or of the body itself if it is not valid JSON.
+func requestHash(body []byte) [sha256.Size]byte {
+	if canonical, err := CanonicalJSON(body); err == nil {
+		body = canonical
+	}
+	return sha256.Sum256(body)
+}
+
+func normalizeContent(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
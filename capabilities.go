@@ -0,0 +1,115 @@
+package gigago
+
+import (
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// modulePath is the import path of the SDK module.
+const modulePath = "github.com/Role1776/gigago"
+
+// Capability names a feature of the SDK that integrations may detect at runtime.
+// Capability names are stable: they are never renamed, and only removed
+// together with the feature in a new major version.
+type Capability string
+
+const (
+	// CapabilityStreaming is GenerativeModel.GenerateStream.
+	CapabilityStreaming Capability = "streaming"
+	// CapabilityFunctions is function calling with GenerativeModel.Functions.
+	CapabilityFunctions Capability = "functions"
+	// CapabilityEmbeddings is Client.Embeddings.
+	CapabilityEmbeddings Capability = "embeddings"
+	// CapabilityFiles is uploading, listing and downloading files.
+	CapabilityFiles Capability = "files"
+	// CapabilityVision is GenerativeModel.GenerateWithImage and Message.Attachments.
+	CapabilityVision Capability = "vision"
+	// CapabilityImageGeneration is GenerativeModel.GenerateImage.
+	CapabilityImageGeneration Capability = "image_generation"
+	// CapabilityStructuredOutput is GenerativeModel.GenerateInto.
+	CapabilityStructuredOutput Capability = "structured_output"
+	// CapabilityTokenCount is Client.CountTokens.
+	CapabilityTokenCount Capability = "token_count"
+	// CapabilityModels is Client.ListModels and Client.ModelInfo.
+	CapabilityModels Capability = "models"
+)
+
+// sdkCapabilities are the capabilities of every client, sorted by name.
+var sdkCapabilities = []Capability{
+	CapabilityEmbeddings,
+	CapabilityFiles,
+	CapabilityFunctions,
+	CapabilityImageGeneration,
+	CapabilityModels,
+	CapabilityStreaming,
+	CapabilityStructuredOutput,
+	CapabilityTokenCount,
+	CapabilityVision,
+}
+
+// experimentalFeatures are the Features reported by Client.Capabilities when enabled.
+var experimentalFeatures = []Feature{FeatureStrictDecoding}
+
+// Capabilities describes what a client supports, in a form that is stable
+// across SDK versions and can be marshaled to JSON, so that frameworks
+// integrating several SDKs can detect features instead of comparing versions.
+type Capabilities struct {
+	// SDKVersion is the version of the SDK (see Version).
+	SDKVersion string `json:"sdk_version"`
+
+	// ModuleVersion is the version of the SDK module recorded in the build
+	// information of the binary, e.g. "v0.1.0" or "(devel)", or "" if unknown.
+	ModuleVersion string `json:"module_version,omitempty"`
+
+	// APIVersion is the GigaChat API version the client uses (see WithAPIVersion).
+	APIVersion string `json:"api_version"`
+
+	// Features are the capabilities of the client, sorted by name.
+	Features []Capability `json:"features"`
+
+	// Experimental are the experimental features enabled for the client (see WithFeature).
+	Experimental []Feature `json:"experimental,omitempty"`
+}
+
+// Has reports whether the client has the capability f.
+func (c Capabilities) Has(f Capability) bool {
+	return slices.Contains(c.Features, f)
+}
+
+// Capabilities returns the capabilities of the client. It does not contact the
+// API: whether a model or gateway supports a feature is not checked.
+func (c *Client) Capabilities() Capabilities {
+	caps := Capabilities{
+		SDKVersion:    Version,
+		ModuleVersion: moduleVersion(),
+		APIVersion:    c.apiVersion,
+		Features:      slices.Clone(sdkCapabilities),
+	}
+	for _, f := range experimentalFeatures {
+		if c.enabled(f) {
+			caps.Experimental = append(caps.Experimental, f)
+		}
+	}
+	return caps
+}
+
+// moduleVersion returns the version of the SDK module in the build information.
+var moduleVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+})
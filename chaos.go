@@ -0,0 +1,122 @@
+package gigago
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FailureRule describes a failure injected into requests matching Endpoint.
+type FailureRule struct {
+	// Endpoint is matched as a substring of the request URL; empty matches every request.
+	Endpoint string
+
+	// Probability is the chance (0-1) that the rule applies to a matching request.
+	Probability float64
+
+	// Latency is added before the request is sent (or failed).
+	Latency time.Duration
+
+	// Status, if non-zero, is returned as a synthetic response instead of sending the request.
+	Status int
+
+	// Err, if set and Status is zero, is returned as a transport error instead of sending the request.
+	// If both Status and Err are zero, the rule only adds Latency.
+	Err error
+}
+
+// FailureInjector injects failures into the requests of a client, for chaos-testing
+// the resilience logic of applications through the real client code paths.
+// It must not be used in production.
+type FailureInjector struct {
+	// Rules are evaluated in order; the first rule that applies wins.
+	Rules []FailureRule
+}
+
+// WithFailureInjector provides an Option to inject failures into the client's requests,
+// including OAuth requests. It is intended for tests only.
+// The HTTP client is copied, so a client passed to WithCustomClient is not modified.
+func WithFailureInjector(injector *FailureInjector) Option {
+	return func(c *Client) {
+		c.failureInjector = injector
+	}
+}
+
+// installFailureInjector wraps the transport of the client with the failure injector, if any.
+func (c *Client) installFailureInjector() {
+	if c.failureInjector == nil {
+		return
+	}
+
+	next := c.httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	httpClient := *c.httpClient
+	httpClient.Transport = &failureTransport{injector: c.failureInjector, next: next, float64: c.randFloat64}
+	c.httpClient = &httpClient
+}
+
+// failureTransport is an http.RoundTripper injecting failures before delegating to next.
+type failureTransport struct {
+	injector *FailureInjector
+	next     http.RoundTripper
+	float64  func() float64
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *failureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, rule := range t.injector.Rules {
+		if !strings.Contains(req.URL.String(), rule.Endpoint) || t.float64() >= rule.Probability {
+			continue
+		}
+
+		if rule.Latency > 0 {
+			timer := time.NewTimer(rule.Latency)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+
+		switch {
+		case rule.Status != 0:
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			body := fmt.Sprintf(`{"status":%d,"message":"injected failure"}`, rule.Status)
+			return &http.Response{
+				Status:        fmt.Sprintf("%d %s", rule.Status, http.StatusText(rule.Status)),
+				StatusCode:    rule.Status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{"Content-Type": []string{"application/json"}},
+				Body:          io.NopCloser(bytes.NewBufferString(body)),
+				ContentLength: int64(len(body)),
+				Request:       req,
+			}, nil
+		case rule.Err != nil:
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, rule.Err
+		}
+		break
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of next, if it supports it,
+// so that Client.Close releases the connections behind the failure injector.
+func (t *failureTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
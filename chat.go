@@ -0,0 +1,182 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChatSession is a conversation with a model that keeps the history of the
+// messages exchanged, so each message is sent along with the previous ones.
+// A ChatSession is not safe for concurrent use.
+type ChatSession struct {
+	model *GenerativeModel
+
+	// History is the conversation so far, without the system instruction of the
+	// model. It may be set to resume a conversation.
+	History []Message
+
+	// MaxHistory, if positive, limits the number of messages kept in History.
+	// The oldest messages are dropped first, such that History always starts
+	// with a user message; the last exchange is kept even if it is longer.
+	MaxHistory int
+
+	// Memory, if set, is the long-term memory of the session: the user messages
+	// sent are stored in it, and the records most relevant to them are recalled
+	// and sent to the model along with them, without being added to History.
+	Memory Memory
+
+	// RecallLimit is the maximum number of records recalled from Memory for
+	// each message. Defaults to 3.
+	RecallLimit int
+}
+
+// defaultRecallLimit is the default value of ChatSession.RecallLimit.
+const defaultRecallLimit = 3
+
+// StartChat starts a conversation with the model.
+func (g *GenerativeModel) StartChat() *ChatSession {
+	return &ChatSession{model: g}
+}
+
+// SendMessage sends text as a user message and returns the model's answer,
+// which is added to the history along with the message.
+func (s *ChatSession) SendMessage(ctx context.Context, text string, opts ...CallOption) (*CompletionResponse, error) {
+	return s.Send(ctx, []Message{{Role: RoleUser, Content: text}}, opts...)
+}
+
+// Send sends the messages, e.g. the result of a function call (see
+// FunctionResultMessage), and returns the model's answer. The messages and
+// the first choice of the answer are added to the history; if the request
+// fails, the history is left unchanged.
+func (s *ChatSession) Send(ctx context.Context, messages []Message, opts ...CallOption) (*CompletionResponse, error) {
+	conversation, request, err := s.request(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.model.Generate(ctx, request, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("response has no choices")
+	}
+	s.commit(ctx, conversation, messages, resp.Choices[0].Message)
+	return resp, nil
+}
+
+// SendStream is like Send, but streams the model's answer (see
+// GenerateStream). The messages and the first choice of the answer are added
+// to the history once the stream is complete, that is when Recv returns
+// io.EOF; if the stream fails or is closed before, the history is left
+// unchanged. The session must not be used until then.
+//
+// When the streamed answer is a function call, its result can be sent with
+// SendFunctionResultStream, so the answer continues streaming in the same
+// turn instead of starting over.
+func (s *ChatSession) SendStream(ctx context.Context, messages []Message, opts ...CallOption) (*Stream, error) {
+	conversation, request, err := s.request(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.model.GenerateStream(ctx, request, opts...)
+	if err != nil {
+		return nil, err
+	}
+	stream.onComplete = func(resp *CompletionResponse) {
+		if len(resp.Choices) > 0 {
+			s.commit(ctx, conversation, messages, resp.Choices[0].Message)
+		}
+	}
+	return stream, nil
+}
+
+// SendFunctionResultStream sends the result of the function call requested by
+// the last answer in the history and streams the continuation of the answer
+// (see SendStream). The result is encoded as by FunctionResultMessage.
+func (s *ChatSession) SendFunctionResultStream(ctx context.Context, result any, opts ...CallOption) (*Stream, error) {
+	if len(s.History) == 0 || s.History[len(s.History)-1].FunctionCall == nil {
+		return nil, errors.New("the last answer is not a function call")
+	}
+	last := s.History[len(s.History)-1]
+	message, err := FunctionResultMessage(last.FunctionCall.Name, result, last.FunctionsStateID)
+	if err != nil {
+		return nil, err
+	}
+	return s.SendStream(ctx, []Message{message}, opts...)
+}
+
+// request returns the conversation made of the history and the messages, and
+// the request to send for it, which also holds the records recalled from Memory.
+func (s *ChatSession) request(ctx context.Context, messages []Message) (conversation, request []Message, err error) {
+	conversation = append(slices.Clip(s.History), messages...)
+	request = conversation
+
+	userTexts := userTexts(messages)
+	if s.Memory != nil && len(userTexts) > 0 {
+		limit := s.RecallLimit
+		if limit <= 0 {
+			limit = defaultRecallLimit
+		}
+		recalled, err := s.Memory.Recall(ctx, strings.Join(userTexts, "\n"), limit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to recall memory: %w", err)
+		}
+		if len(recalled) > 0 {
+			request = append(slices.Clip(s.History), memoryMessage(recalled))
+			request = append(request, messages...)
+		}
+	}
+	return conversation, request, nil
+}
+
+// commit adds the answer to the conversation, which becomes the history, and
+// stores the user messages sent in Memory.
+func (s *ChatSession) commit(ctx context.Context, conversation, messages []Message, answer ResponseMessage) {
+	s.History = append(conversation, answer.Message())
+	s.trimHistory()
+
+	userTexts := userTexts(messages)
+	if s.Memory != nil && len(userTexts) > 0 {
+		records := make([]MemoryRecord, len(userTexts))
+		for i, text := range userTexts {
+			records[i] = MemoryRecord{Text: text}
+		}
+		// The answer is valid even if it cannot be remembered.
+		if err := s.Memory.Store(ctx, records...); err != nil {
+			s.model.c.logf("failed to store chat messages in memory: %v", err)
+		}
+	}
+}
+
+// userTexts returns the non-empty contents of the user messages.
+func userTexts(messages []Message) []string {
+	var texts []string
+	for _, m := range messages {
+		if m.Role == RoleUser && m.Content != "" {
+			texts = append(texts, m.Content)
+		}
+	}
+	return texts
+}
+
+// trimHistory drops the oldest messages beyond MaxHistory.
+func (s *ChatSession) trimHistory() {
+	if s.MaxHistory <= 0 || len(s.History) <= s.MaxHistory {
+		return
+	}
+	start := len(s.History) - s.MaxHistory
+	for start < len(s.History) && s.History[start].Role != RoleUser {
+		start++
+	}
+	if start == len(s.History) {
+		// The last exchange is longer than MaxHistory; keep it whole.
+		start = len(s.History) - s.MaxHistory
+		for start > 0 && s.History[start].Role != RoleUser {
+			start--
+		}
+	}
+	s.History = slices.Clone(s.History[start:])
+}
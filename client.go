@@ -3,17 +3,28 @@ package gigago
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	defaultBaseURLForAI    = "https://gigachat.devices.sberbank.ru/api/v1/chat/completions"
-	defaultBaseURLForOauth = "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
-	defaultTimeout         = 30 * time.Second
-	defaultScope           = "GIGACHAT_API_PERS"
+	defaultBaseURLForAPI       = "https://gigachat.devices.sberbank.ru/api"
+	defaultAPIVersion          = "v1"
+	completionsPath            = "/chat/completions"
+	defaultBaseURLForOauth     = "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
+	defaultRequestTimeout      = 2 * time.Minute
+	defaultStreamIdleTimeout   = 30 * time.Second
+	defaultMaxIdleConnsPerHost = 16
+	defaultScope               = "GIGACHAT_API_PERS"
 )
 
 // Client is the main entry point for interacting with the GigaChat API.
@@ -25,18 +36,107 @@ type Client struct {
 	httpClient *http.Client
 	// baseURLAI is the base URL for the main chat completions API.
 	baseURLAI string
+	// apiVersion is the version of the API used to build the default URLs.
+	apiVersion string
 	// baseURLOauth is the base URL for the OAuth 2.0 token endpoint.
 	baseURLOauth string
 	// scope defines the permission scope for the access token.
-	scope          string
-	apiKey         string
-	mu             sync.RWMutex
-	wg             *sync.WaitGroup
-	accessToken    *tokenResponse
-	ctxCancel      context.CancelFunc
-	refreshMu      sync.Mutex
-	refreshing     bool
-	refreshWaiters []chan error
+	scope       string
+	apiKey      string
+	mu          sync.RWMutex
+	wg          sync.WaitGroup
+	accessToken *tokenResponse
+	// credentialTokens caches access tokens for credentials passed via ContextWithCredentials.
+	credentialTokens map[credentials]*tokenResponse
+	// ctx is the context of the client, canceled by Close.
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	refreshMu sync.Mutex
+	// refresh is the token refresh in flight, if any, shared by its callers.
+	refresh *refreshCall
+	// credentialRefreshes are the refreshes of credentialTokens in flight.
+	credentialRefreshes map[credentials]*refreshCall
+	// moderation configures the optional input pre-check.
+	moderation *InputModeration
+	// denylist holds a regexp per category of InputModeration.Denylist.
+	denylist map[string]*regexp.Regexp
+	// customRoles are message roles allowed in addition to the known ones.
+	customRoles map[Role]struct{}
+	// features holds the settings of experimental features.
+	features map[Feature]bool
+	// stats holds the counters reported by Stats.
+	stats clientStats
+	// usage holds the per-model usage reported by UsageSince and ResetUsage.
+	usage usageTracker
+	// expvarName is the name the statistics are published under, if any.
+	expvarName string
+	// auditLog records API calls, if enabled.
+	auditLog *auditLog
+	// logger receives warnings and background errors.
+	logger *log.Logger
+	// contextWindowWarning is the context window usage ratio above which a warning is logged.
+	contextWindowWarning float64
+	// refusalDetector detects refusal-style answers.
+	refusalDetector func(content string, finishReason FinishReason) bool
+	// limiter limits the rate of outgoing API requests, if set.
+	limiter Limiter
+	// queue limits the number of calls in flight, if set by WithMaxConcurrentRequests.
+	queue *requestQueue
+	// rand is the source of randomness set by WithRandSource, if any.
+	rand *lockedRand
+	// requestTimeout limits the duration of each request, if positive.
+	requestTimeout time.Duration
+	// retry is the retry policy set by WithRetry, if any.
+	retry *retryPolicy
+	// responseValidation rejects completions without an answer.
+	responseValidation bool
+	// streamIdleTimeout limits the time between two reads of a stream, if positive.
+	streamIdleTimeout time.Duration
+	// modelsTTL is how long the result of ListModels is cached, if positive.
+	modelsTTL time.Duration
+	// models caches the result of ListModels.
+	models modelsCache
+	// semanticCache is the cache of WithSemanticCache, if enabled.
+	semanticCache *SemanticCache
+	// streamQuota throttles the streamed tokens of each user, if set.
+	streamQuota *streamQuota
+	// lazyAuth defers the initial token fetch to the first request.
+	lazyAuth bool
+	// headers are sent with every request.
+	headers http.Header
+	// sticky is the routing header set by WithStickyHeader, if any.
+	sticky *stickyHeader
+	// modelUpgrades maps models to the larger-context models they may be replaced with.
+	modelUpgrades map[string]string
+	// balancer spreads requests over the keys set by WithKeyBalancing, if any.
+	balancer *keyBalancer
+	// resolver resolves host names, if set.
+	resolver *net.Resolver
+	// pinnedIPs maps host names to the IP addresses connections are made to.
+	pinnedIPs map[string]string
+	// certPins are the SHA-256 hashes of the server certificates trusted by WithPinnedServerCert.
+	certPins [][]byte
+	// compatibilityURL is the URL of the compatibility manifest checked by NewClient, if any.
+	compatibilityURL string
+	// fallback keeps the responses served by WithStaleFallback, if set.
+	fallback *fallbackCache
+	// strictSecurity makes NewClient refuse insecure settings.
+	strictSecurity bool
+	// rootCAs are the CAs trusted by WithRootCAs and WithRootCAFile, if set.
+	rootCAs *x509.CertPool
+	// clientCert authenticates requests instead of access tokens, if set.
+	clientCert *tls.Certificate
+	// tokenProvider obtains access tokens instead of the OAuth endpoint, if set.
+	tokenProvider TokenProvider
+	// tokenStore shares the access token with other processes, if set.
+	tokenStore TokenStore
+	// failureInjector injects failures for chaos testing, if set.
+	failureInjector *FailureInjector
+	// httpClientOptions are the names of the options that configured httpClient,
+	// whose settings WithCustomClient would discard.
+	httpClientOptions []string
+	// optionErrs are the conflicts found while applying the options, reported by NewClient.
+	optionErrs []error
 	// for testing
 	oauthCreateFunc func(ctx context.Context) (*tokenResponse, error)
 }
@@ -47,12 +147,35 @@ type Option func(*Client)
 
 // WithCustomURLAI provides an Option to set a custom base URL for the main AI API.
 // This is primarily used for testing or connecting to a proxy.
+// The URL is used as is for chat completions; other endpoints are resolved
+// relative to it with the "/chat/completions" suffix removed, so
+// "https://proxy/api/v1/chat/completions" serves models from "https://proxy/api/v1/models".
+// A custom URL takes precedence over WithAPIVersion.
 func WithCustomURLAI(url string) Option {
 	return func(c *Client) {
 		c.baseURLAI = url
 	}
 }
 
+// WithLazyAuth provides an Option to defer obtaining the access token until the
+// first request, so NewClient neither blocks on nor fails because of the OAuth
+// endpoint. This suits serverless cold starts, where the token may never be needed.
+// Authentication errors are then returned by the first request instead.
+func WithLazyAuth() Option {
+	return func(c *Client) {
+		c.lazyAuth = true
+	}
+}
+
+// WithAPIVersion provides an Option to pin the version of the GigaChat API
+// ("v1" or "v2") used to build the default endpoint URLs. Defaults to "v1".
+// NewClient fails for unsupported versions.
+func WithAPIVersion(version string) Option {
+	return func(c *Client) {
+		c.apiVersion = version
+	}
+}
+
 // WithCustomURLOauth provides an Option to set a custom base URL for the OAuth 2.0 endpoint.
 // This is primarily used for testing or connecting to a proxy.
 func WithCustomURLOauth(url string) Option {
@@ -63,11 +186,21 @@ func WithCustomURLOauth(url string) Option {
 
 // WithCustomClient provides an Option to use a custom http.Client.
 // This is the recommended way for advanced configuration, such as setting custom
-// transport for proxies or mTLS. If this option is used, it should typically
-// be the FIRST option passed to NewClient, as other options like WithCustomTimeout
-// or WithCustomInsecureSkipVerify will modify the provided client.
+// transport for proxies or mTLS. If this option is used, it must be passed
+// before options like WithCustomTimeout or WithCustomInsecureSkipVerify, which
+// modify the provided client; NewClient fails if it would discard their settings.
+// Options configuring the transport require the client's Transport to be nil
+// or an *http.Transport.
 func WithCustomClient(client *http.Client) Option {
 	return func(c *Client) {
+		if client == nil {
+			c.optionErrs = append(c.optionErrs, errors.New("WithCustomClient: client is nil"))
+			return
+		}
+		if len(c.httpClientOptions) > 0 {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithCustomClient would discard the settings of %s; pass it first",
+				strings.Join(c.httpClientOptions, ", ")))
+		}
 		c.httpClient = client
 	}
 }
@@ -75,12 +208,15 @@ func WithCustomClient(client *http.Client) Option {
 // WithCustomTimeout provides an Option to set a custom timeout for the http.Client.
 // If WithCustomClient is also used, this option will be applied to the custom client,
 // potentially overwriting its original timeout.
+// The timeout of the http.Client applies to every request, including the time
+// to read the response body; prefer WithRequestTimeout.
 func WithCustomTimeout(timeout time.Duration) Option {
 	return func(c *Client) {
 		if c.httpClient == nil {
 			c.httpClient = &http.Client{}
 		}
 		c.httpClient.Timeout = timeout
+		c.httpClientOptions = append(c.httpClientOptions, "WithCustomTimeout")
 	}
 }
 
@@ -95,20 +231,12 @@ func WithCustomScope(scope string) Option {
 // WithCustomInsecureSkipVerify provides an Option to control SSL/TLS certificate verification.
 // WARNING: Setting this to true disables certificate validation and makes the connection
 // vulnerable to man-in-the-middle attacks. This should only be used for
-// specific testing or development scenarios with trusted networks.
+// specific testing or development scenarios with trusted networks; to connect
+// to the GigaChat endpoints, trust their root CA with WithRootCAFile instead.
 // By default, verification is enabled (false).
 func WithCustomInsecureSkipVerify(insecureSkipVerify bool) Option {
 	return func(c *Client) {
-		if c.httpClient == nil {
-			c.httpClient = &http.Client{}
-		}
-
-		transport, ok := c.httpClient.Transport.(*http.Transport)
-		if !ok {
-			transport = &http.Transport{}
-			c.httpClient.Transport = transport
-		}
-
+		transport := c.transport("WithCustomInsecureSkipVerify")
 		if transport.TLSClientConfig == nil {
 			transport.TLSClientConfig = &tls.Config{}
 		}
@@ -117,54 +245,171 @@ func WithCustomInsecureSkipVerify(insecureSkipVerify bool) Option {
 	}
 }
 
+// WithRequestTimeout provides an Option to limit the duration of each API request,
+// including retries after a token refresh. Defaults to 2 minutes, which leaves
+// room for long generations. A zero timeout disables the limit; deadlines of
+// the request context still apply.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.requestTimeout = timeout
+	}
+}
+
+// WithResponseHeaderTimeout provides an Option to limit the time to wait for the
+// response headers after the request has been written. Long generations delay
+// the headers of non-streaming responses, so this should be generous; it allows
+// failing fast on unresponsive gateways independently of the overall timeout.
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.transport("WithResponseHeaderTimeout").ResponseHeaderTimeout = timeout
+	}
+}
+
+// WithExpectContinueTimeout provides an Option to set how long to wait for the
+// server's first response headers after sending request headers with
+// "Expect: 100-continue". Some proxies never answer 100-continue, which makes
+// requests with large bodies (e.g. file uploads) stall for this long.
+func WithExpectContinueTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.transport("WithExpectContinueTimeout").ExpectContinueTimeout = timeout
+	}
+}
+
+// transport returns the *http.Transport of the client's HTTP client for the
+// named option, creating the HTTP client and the transport if needed. A nil
+// transport is replaced with a copy of http.DefaultTransport. A transport of
+// another type cannot be configured: the conflict is recorded and a detached
+// transport is returned.
+func (c *Client) transport(option string) *http.Transport {
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+	}
+	c.httpClientOptions = append(c.httpClientOptions, option)
+
+	switch transport := c.httpClient.Transport.(type) {
+	case *http.Transport:
+		return transport
+	case nil:
+		clone := http.DefaultTransport.(*http.Transport).Clone()
+		c.httpClient.Transport = clone
+		return clone
+	default:
+		c.optionErrs = append(c.optionErrs, fmt.Errorf("%s requires the transport of the HTTP client to be an *http.Transport, got %T", option, transport))
+		return &http.Transport{}
+	}
+}
+
+// checkOptions returns the conflicts found while applying the options and
+// logs combinations that are valid but likely surprising.
+func (c *Client) checkOptions() error {
+	errs := c.optionErrs
+	if c.clientCert != nil && c.apiKey != "" {
+		errs = append(errs, errors.New("WithClientCertificate: the authorization key would be ignored; pass an empty key or drop the certificate"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	if timeout := c.httpClient.Timeout; timeout > 0 && c.requestTimeout > 0 {
+		c.logf("both the HTTP client timeout %s and the request timeout %s apply: the shorter one wins, and the HTTP client timeout also cuts off streams; prefer WithRequestTimeout", timeout, c.requestTimeout)
+	}
+	return nil
+}
+
 // NewClient creates, configures, and returns a new Client instance.
 // It requires an API key for authentication and accepts a variadic number of
 // Option functions to customize its behavior (e.g., setting custom URLs or HTTP client).
 //
-// On initialization, it performs an initial request to obtain an access token.
+// On initialization, it performs an initial request to obtain an access token
+// (unless WithLazyAuth is used).
 // It also launches a background goroutine to automatically refresh the token before it expires.
 // An error is returned if the initial token fetch fails.
 func NewClient(ctx context.Context, apiKey string, opts ...Option) (*Client, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("apiKey cannot be empty")
-	}
-
 	client := &Client{
 		apiKey:       apiKey,
-		baseURLAI:    defaultBaseURLForAI,
+		apiVersion:   defaultAPIVersion,
 		baseURLOauth: defaultBaseURLForOauth,
 		scope:        defaultScope,
 		httpClient: &http.Client{
 			Transport: &http.Transport{
 				TLSClientConfig: &tls.Config{
 					InsecureSkipVerify: false,
+					// Resume TLS sessions on new connections (see Prewarm).
+					ClientSessionCache: tls.NewLRUClientSessionCache(0),
 				},
+				MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
 			},
-			Timeout: defaultTimeout,
 		},
-		wg: &sync.WaitGroup{},
+		features:          featuresFromEnv(),
+		requestTimeout:    defaultRequestTimeout,
+		streamIdleTimeout: defaultStreamIdleTimeout,
+		modelsTTL:         defaultModelsTTL,
 	}
 
 	ctxWithCancel, cancel := context.WithCancel(context.Background())
-	client.ctxCancel = cancel
+	client.ctx, client.ctxCancel = ctxWithCancel, cancel
 
 	for _, opt := range opts {
 		opt(client)
 	}
 
-	access, err := client.oauthCreate(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("token fetch failed: %w", err)
+	if !slices.Contains(supportedAPIVersions, client.apiVersion) {
+		return nil, fmt.Errorf("unsupported API version %q", client.apiVersion)
+	}
+	if client.baseURLAI == "" {
+		client.baseURLAI = defaultBaseURLForAPI + "/" + client.apiVersion + completionsPath
 	}
 
-	client.accessToken = access
+	client.installDialer()
+	client.installClientCert()
+	client.installRootCAs()
+	if err := client.installCertPins(); err != nil {
+		return nil, err
+	}
+	if err := client.checkOptions(); err != nil {
+		return nil, err
+	}
+	if apiKey == "" && client.tokenProvider == nil && client.clientCert == nil {
+		return nil, fmt.Errorf("apiKey cannot be empty")
+	}
+	if err := client.checkSecurity(); err != nil {
+		return nil, err
+	}
+	client.installFailureInjector()
+
+	if !client.lazyAuth && client.clientCert == nil {
+		access, err := client.obtainToken(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("token fetch failed: %w", err)
+		}
+
+		client.accessToken = access
+	}
 
-	client.wg.Add(1)
-	go client.tokenRefresher(ctxWithCancel)
+	if err := client.publishExpvar(); err != nil {
+		return nil, err
+	}
+
+	client.checkCompatibility(ctx)
+
+	// Certificate authentication needs no token to refresh.
+	if client.clientCert == nil {
+		client.wg.Add(1)
+		go client.tokenRefresher(ctxWithCancel)
+	}
 
 	return client, nil
 }
 
+// supportedAPIVersions lists the values accepted by WithAPIVersion.
+var supportedAPIVersions = []string{"v1", "v2"}
+
+// apiURL returns the URL of the API endpoint at path (e.g. "/models"),
+// relative to the root of the configured API URL.
+func (c *Client) apiURL(path string) string {
+	root := strings.TrimSuffix(c.baseURLAI, completionsPath)
+	return strings.TrimSuffix(root, "/") + path
+}
+
 // Close gracefully shuts down the client. It closes idle HTTP connections
 // and stops the background token refresher goroutine. It's recommended to
 // call Close when the client is no longer needed to prevent resource leaks.
@@ -172,4 +417,5 @@ func (c *Client) Close() {
 	c.ctxCancel()
 	c.wg.Wait()
 	c.httpClient.CloseIdleConnections()
+	c.unpublishExpvar()
 }
@@ -0,0 +1,52 @@
+package gigago
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// WithClientCertificate provides an Option to authenticate with a TLS client
+// certificate instead of an access token, for accounts set up for certificate
+// authentication. certPEM and keyPEM are the PEM encoded certificate (with its
+// intermediates, if any) and private key.
+//
+// With a client certificate, requests carry no Authorization header, no token
+// is requested from the OAuth endpoint and the background token refresher is
+// not started, so the authorization key passed to NewClient must be empty:
+// NewClient fails if both are given, as the key would be ignored. Requests made
+// with ContextWithCredentials still use access tokens.
+func WithClientCertificate(certPEM, keyPEM []byte) Option {
+	return func(c *Client) {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithClientCertificate: %w", err))
+			return
+		}
+		c.clientCert = &cert
+	}
+}
+
+// WithClientCertificateFile is like WithClientCertificate, but reads the
+// certificate and the private key from PEM files.
+func WithClientCertificateFile(certFile, keyFile string) Option {
+	return func(c *Client) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithClientCertificateFile: %w", err))
+			return
+		}
+		c.clientCert = &cert
+	}
+}
+
+// installClientCert sets up the TLS configuration of the transport for WithClientCertificate.
+func (c *Client) installClientCert() {
+	if c.clientCert == nil {
+		return
+	}
+	transport := c.transport("WithClientCertificate")
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{*c.clientCert}
+}
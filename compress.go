@@ -0,0 +1,186 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// defaultStopwords are low-information words removed by PromptCompressor.
+// Negations are deliberately not included, as dropping them changes the meaning.
+var defaultStopwords = []string{
+	// English
+	"a", "an", "the", "is", "are", "was", "were", "be", "been", "being", "am",
+	"of", "to", "in", "on", "at", "by", "for", "with", "from", "as", "into",
+	"that", "this", "these", "those", "it", "its", "and", "or", "so", "then",
+	"very", "really", "just", "quite", "please", "kindly", "basically", "actually",
+	// Russian
+	"и", "в", "во", "на", "с", "со", "к", "ко", "по", "о", "об", "от", "у", "за",
+	"из", "же", "ли", "бы", "то", "это", "этот", "эта", "эти", "так", "вот", "ну",
+	"очень", "просто", "пожалуйста", "как", "ведь", "уж", "также", "тоже",
+}
+
+const compressionInstruction = `Compress the user's text to as few tokens as possible for another language model to read.
+Keep every fact, number, name, instruction and constraint. Drop pleasantries, repetitions and filler words.
+Reply with the compressed text only.`
+
+// CompressionStats reports the effect of prompt compression.
+type CompressionStats struct {
+	// OriginalTokens is the number of tokens before compression.
+	OriginalTokens int
+
+	// CompressedTokens is the number of tokens after compression.
+	CompressedTokens int
+
+	// Measured is true if the token counts were measured by the API
+	// rather than estimated.
+	Measured bool
+}
+
+// Saved returns the number of tokens saved by compression.
+func (s CompressionStats) Saved() int {
+	return s.OriginalTokens - s.CompressedTokens
+}
+
+// Ratio returns the size of the compressed prompt relative to the original one.
+func (s CompressionStats) Ratio() float64 {
+	if s.OriginalTokens == 0 {
+		return 1
+	}
+	return float64(s.CompressedTokens) / float64(s.OriginalTokens)
+}
+
+// PromptCompressor shrinks prompts before they are sent to an expensive model.
+//
+// It always removes stopwords, collapses whitespace and drops repeated lines.
+// If Model is set, the result is further compressed by that (usually cheap)
+// model and the token savings are measured with the API; otherwise they are estimated.
+type PromptCompressor struct {
+	// Model, if set, is used to compress the text and to count tokens.
+	Model *GenerativeModel
+
+	// Stopwords replaces the default list of removed words. Matching is case-insensitive.
+	Stopwords []string
+
+	// Roles lists the roles of the messages to compress. Defaults to RoleUser only.
+	Roles []Role
+}
+
+// Compress returns compressed copies of the messages and the token savings.
+// Messages with roles not listed in Roles are returned unchanged.
+func (p *PromptCompressor) Compress(ctx context.Context, messages []Message) ([]Message, CompressionStats, error) {
+	roles := p.Roles
+	if len(roles) == 0 {
+		roles = []Role{RoleUser}
+	}
+	stopwords := p.Stopwords
+	if stopwords == nil {
+		stopwords = defaultStopwords
+	}
+
+	out := make([]Message, len(messages))
+	var original, compressed []string
+	for i, m := range messages {
+		out[i] = m
+		if !slices.Contains(roles, m.Role) {
+			continue
+		}
+
+		text := removeStopwords(dropRepeatedLines(m.Content), stopwords)
+		if p.Model != nil {
+			model := p.Model.withSystemInstruction(compressionInstruction)
+			resp, err := model.Generate(ctx, []Message{{Role: RoleUser, Content: text}})
+			if err != nil {
+				return nil, CompressionStats{}, fmt.Errorf("failed to compress message %d: %w", i, err)
+			}
+			if len(resp.Choices) > 0 && strings.TrimSpace(resp.Choices[0].Message.Content) != "" {
+				text = strings.TrimSpace(resp.Choices[0].Message.Content)
+			}
+		}
+
+		original = append(original, m.Content)
+		compressed = append(compressed, text)
+		out[i].Content = text
+	}
+
+	stats, err := p.measure(ctx, original, compressed)
+	if err != nil {
+		return nil, CompressionStats{}, err
+	}
+	return out, stats, nil
+}
+
+// measure counts the tokens of the original and compressed texts.
+func (p *PromptCompressor) measure(ctx context.Context, original, compressed []string) (CompressionStats, error) {
+	var stats CompressionStats
+	if len(original) == 0 {
+		return stats, nil
+	}
+
+	if p.Model == nil {
+		for i := range original {
+			stats.OriginalTokens += estimateTokens(original[i])
+			stats.CompressedTokens += estimateTokens(compressed[i])
+		}
+		return stats, nil
+	}
+
+	counts, err := p.Model.c.CountTokens(ctx, p.Model.fullName, append(slices.Clone(original), compressed...)...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	for i, count := range counts {
+		if i < len(original) {
+			stats.OriginalTokens += count.Tokens
+		} else {
+			stats.CompressedTokens += count.Tokens
+		}
+	}
+	stats.Measured = true
+	return stats, nil
+}
+
+// dropRepeatedLines removes lines that already occurred earlier in the text,
+// which typically are boilerplate such as signatures or disclaimers.
+func dropRepeatedLines(text string) string {
+	lines := strings.Split(text, "\n")
+	seen := make(map[string]struct{}, len(lines))
+	kept := lines[:0]
+	for _, line := range lines {
+		key := strings.TrimSpace(line)
+		if key != "" {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// removeStopwords removes the stopwords from text and collapses whitespace,
+// keeping line breaks.
+func removeStopwords(text string, stopwords []string) string {
+	set := make(map[string]struct{}, len(stopwords))
+	for _, w := range stopwords {
+		set[strings.ToLower(w)] = struct{}{}
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		words := strings.Fields(line)
+		kept := words[:0]
+		for _, w := range words {
+			bare := strings.ToLower(strings.TrimFunc(w, unicode.IsPunct))
+			if _, ok := set[bare]; ok && bare == strings.ToLower(w) {
+				continue
+			}
+			kept = append(kept, w)
+		}
+		lines[i] = strings.Join(kept, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,19 @@
+package gigago
+
+import "context"
+
+type costCenterKey struct{}
+
+// ContextWithCostCenter returns a copy of ctx that attributes the token usage of
+// requests issued with it to the given cost center (e.g. a team or a feature).
+// Usage by cost center is reported in Usage.CostCenters and Stats.CostCenters,
+// so one shared client can produce per-team cost breakdowns.
+func ContextWithCostCenter(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, costCenterKey{}, name)
+}
+
+// costCenterFromContext returns the cost center stored in ctx, or "" if none.
+func costCenterFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(costCenterKey{}).(string)
+	return name
+}
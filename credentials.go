@@ -0,0 +1,215 @@
+package gigago
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoAccessToken is returned (wrapped) by requests when the client has no
+// valid access token and fails to obtain one, e.g. because the OAuth endpoint
+// kept failing past the expiry of the previous token.
+var ErrNoAccessToken = errors.New("no valid access token")
+
+type credentialsKey struct{}
+
+// credentials identify an authorization key and scope used instead of the client's own.
+type credentials struct {
+	apiKey string
+	scope  string
+}
+
+// ContextWithCredentials returns a copy of ctx that makes requests issued with it
+// authenticate with apiKey and scope instead of the client's own credentials.
+// An empty scope means the client's scope.
+//
+// This allows one client to serve requests on behalf of several accounts, e.g. a
+// support engineer replaying a customer's request under that customer's key.
+// Access tokens for such credentials are fetched on demand and cached by the client.
+func ContextWithCredentials(ctx context.Context, apiKey, scope string) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, credentials{apiKey: apiKey, scope: scope})
+}
+
+// credentialsFromContext returns the credentials stored in ctx, if any.
+func (c *Client) credentialsFromContext(ctx context.Context) (credentials, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(credentials)
+	if !ok || creds.apiKey == "" {
+		return credentials{}, false
+	}
+	if creds.scope == "" {
+		creds.scope = c.scope
+	}
+	return creds, true
+}
+
+// token returns the access token to use for a request made with ctx, or ""
+// if the request is authenticated with a client certificate.
+func (c *Client) token(ctx context.Context) (string, error) {
+	if creds, ok := c.credentialsFromContext(ctx); ok {
+		return c.credentialsToken(ctx, creds, false)
+	}
+	if c.clientCert != nil {
+		return "", nil
+	}
+
+	c.mu.RLock()
+	token := c.accessToken
+	c.mu.RUnlock()
+
+	// With lazy authentication the first request obtains the token. A token
+	// that expired because background refreshes kept failing is refreshed
+	// synchronously instead of being sent only to be rejected.
+	if !usableToken(token, time.Now()) {
+		var stale string
+		if token != nil {
+			stale = token.AccessToken
+		}
+		if err := c.refreshToken(ctx, stale); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrNoAccessToken, err)
+		}
+		c.mu.RLock()
+		token = c.accessToken
+		c.mu.RUnlock()
+		if !usableToken(token, time.Now()) {
+			return "", ErrNoAccessToken
+		}
+	}
+	return token.AccessToken, nil
+}
+
+// usableToken reports whether token may be sent with a request. A token
+// without an expiration time is assumed to be valid.
+func usableToken(token *tokenResponse, now time.Time) bool {
+	if token == nil {
+		return false
+	}
+	return token.ExpiresAt == 0 || unixTime(token.ExpiresAt).After(now)
+}
+
+// reauth obtains a new access token for requests made with ctx after the
+// token rejected was refused by the API.
+func (c *Client) reauth(ctx context.Context, rejected string) error {
+	if creds, ok := c.credentialsFromContext(ctx); ok {
+		_, err := c.credentialsToken(ctx, creds, true)
+		return err
+	}
+	return c.refreshToken(ctx, rejected)
+}
+
+// maxCredentialTokens is the number of access tokens cached for credentials
+// passed via ContextWithCredentials; expired tokens are evicted first.
+const maxCredentialTokens = 1024
+
+// credentialsToken returns a cached access token for creds, fetching a new one
+// if none is cached, the cached one is about to expire, or force is set.
+// Concurrent calls for the same credentials share a single OAuth request, which
+// is not canceled with ctx, as in refreshToken.
+func (c *Client) credentialsToken(ctx context.Context, creds credentials, force bool) (string, error) {
+	if !force {
+		c.mu.RLock()
+		token, ok := c.credentialTokens[creds]
+		c.mu.RUnlock()
+		if ok && c.isValid(token.ExpiresAt, time.Now()) {
+			return token.AccessToken, nil
+		}
+	}
+
+	c.refreshMu.Lock()
+	call, ok := c.credentialRefreshes[creds]
+	if !ok {
+		call = &refreshCall{done: make(chan struct{})}
+		if c.credentialRefreshes == nil {
+			c.credentialRefreshes = make(map[credentials]*refreshCall)
+		}
+		c.credentialRefreshes[creds] = call
+		c.wg.Add(1)
+		go c.runCredentialsRefresh(ctx, call, creds)
+	}
+	c.refreshMu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return "", call.err
+		}
+		return call.token.AccessToken, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// runCredentialsRefresh obtains the token of call for creds and caches it.
+func (c *Client) runCredentialsRefresh(ctx context.Context, call *refreshCall, creds credentials) {
+	defer c.wg.Done()
+	ctx, cancel := c.detachedContext(ctx)
+	defer cancel()
+
+	token, err := c.oauthCreateFor(ctx, creds.apiKey, creds.scope)
+	if err == nil {
+		c.mu.Lock()
+		c.cacheCredentialsToken(creds, token)
+		c.mu.Unlock()
+	}
+
+	c.refreshMu.Lock()
+	call.token, call.err = token, err
+	delete(c.credentialRefreshes, creds)
+	close(call.done)
+	c.refreshMu.Unlock()
+}
+
+// cacheCredentialsToken stores the token of creds, evicting expired tokens and,
+// if the cache is still full, an arbitrary one. c.mu must be held.
+func (c *Client) cacheCredentialsToken(creds credentials, token *tokenResponse) {
+	if c.credentialTokens == nil {
+		c.credentialTokens = make(map[credentials]*tokenResponse)
+	}
+	if _, ok := c.credentialTokens[creds]; !ok && len(c.credentialTokens) >= maxCredentialTokens {
+		now := time.Now()
+		for k, t := range c.credentialTokens {
+			if !usableToken(t, now) {
+				delete(c.credentialTokens, k)
+			}
+		}
+		for k := range c.credentialTokens {
+			if len(c.credentialTokens) < maxCredentialTokens {
+				break
+			}
+			delete(c.credentialTokens, k)
+		}
+	}
+	c.credentialTokens[creds] = token
+}
+
+// AuthorizationKey returns the authorization key of the client ID and client
+// secret of a GigaChat API project, as expected by NewClient and
+// ContextWithCredentials: the Base64 encoding of "clientID:clientSecret".
+// Surrounding whitespace, often left by copying the values from files or
+// environment variables, is removed.
+func AuthorizationKey(clientID, clientSecret string) (string, error) {
+	clientID = strings.TrimSpace(clientID)
+	clientSecret = strings.TrimSpace(clientSecret)
+	switch {
+	case clientID == "":
+		return "", errors.New("client ID cannot be empty")
+	case clientSecret == "":
+		return "", errors.New("client secret cannot be empty")
+	case strings.Contains(clientID, ":"):
+		return "", errors.New("client ID cannot contain ':'; pass the client ID and the client secret separately")
+	}
+	return base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret)), nil
+}
+
+// NewClientWithCredentials is like NewClient, but takes the client ID and the
+// client secret of a GigaChat API project instead of an authorization key
+// already encoded with them (see AuthorizationKey).
+func NewClientWithCredentials(ctx context.Context, clientID, clientSecret string, opts ...Option) (*Client, error) {
+	apiKey, err := AuthorizationKey(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(ctx, apiKey, opts...)
+}
@@ -0,0 +1,56 @@
+package gigago
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// WithResolver provides an Option to resolve host names with r, e.g. a resolver
+// querying internal DNS servers, instead of the system resolver. It replaces
+// the dial function of the transport but keeps its other settings.
+func WithResolver(r *net.Resolver) Option {
+	return func(c *Client) {
+		c.resolver = r
+	}
+}
+
+// WithPinnedIP provides an Option to connect to ip whenever host is requested,
+// bypassing DNS, as required by some security policies. TLS certificates are
+// still verified against host. It may be used several times.
+func WithPinnedIP(host, ip string) Option {
+	return func(c *Client) {
+		if c.pinnedIPs == nil {
+			c.pinnedIPs = make(map[string]string)
+		}
+		c.pinnedIPs[host] = ip
+	}
+}
+
+// installDialer sets up the dial function of the transport for WithResolver and WithPinnedIP.
+func (c *Client) installDialer() {
+	if c.resolver == nil && len(c.pinnedIPs) == 0 {
+		return
+	}
+
+	option := "WithPinnedIP"
+	if c.resolver != nil {
+		option = "WithResolver"
+	}
+	transport := c.transport(option)
+	dial := transport.DialContext
+	if dial == nil || c.resolver != nil {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: c.resolver}
+		dial = dialer.DialContext
+	}
+
+	pinned := c.pinnedIPs
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := pinned[host]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,181 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Document is a piece of retrieved context, e.g. a chunk found by a search
+// index in a RAG application.
+type Document struct {
+	// ID identifies the document in citations. Defaults to the 1-based position
+	// of the document in the list passed to ContextMessage.
+	ID string `json:"id,omitempty"`
+
+	// Title is the title of the document, if any.
+	Title string `json:"title,omitempty"`
+
+	// Source is the origin of the document, e.g. a URL or a file name.
+	Source string `json:"source,omitempty"`
+
+	// Content is the text of the document.
+	Content string `json:"content"`
+}
+
+const contextInstruction = "Answer using the documents below. After each statement based on a document, cite it by its ID in square brackets, e.g. [1]. Do not cite documents you did not use."
+
+// documentTagPattern matches the opening and closing tags of the context block
+// of ContextMessage.
+var documentTagPattern = regexp.MustCompile(`(?i)<(\s*/?\s*document)`)
+
+// ContextMessage returns a user message with the documents as a structured
+// context block, which asks the model to cite the documents it uses by ID in
+// square brackets. The message should precede the question; ExtractCitations
+// and CitedDocuments map the citations in the answer back to the documents.
+//
+// Document tags in the content are escaped, so that a document cannot close
+// its block and pose as the rest of the message.
+func ContextMessage(docs ...Document) Message {
+	var sb strings.Builder
+	sb.WriteString(contextInstruction)
+	for _, doc := range withDocumentIDs(docs) {
+		fmt.Fprintf(&sb, "\n\n<document id=%q", doc.ID)
+		if doc.Title != "" {
+			fmt.Fprintf(&sb, " title=%q", doc.Title)
+		}
+		if doc.Source != "" {
+			fmt.Fprintf(&sb, " source=%q", doc.Source)
+		}
+		sb.WriteString(">\n")
+		sb.WriteString(documentTagPattern.ReplaceAllString(strings.TrimSpace(doc.Content), "&lt;$1"))
+		sb.WriteString("\n</document>")
+	}
+	return Message{Role: RoleUser, Content: sb.String()}
+}
+
+// citationPattern matches citation markers such as "[1]" or "[2, doc-3]".
+var citationPattern = regexp.MustCompile(`\[([^\[\]\n]{1,256})\]`)
+
+// maxCitationMarker is the maximum length of a citation marker in bytes.
+const maxCitationMarker = 258
+
+// Citation is a reference to a document found in the text of an answer.
+type Citation struct {
+	// Document is the cited document.
+	Document Document
+
+	// Start and End are the byte offsets of the citation marker in the text.
+	// A marker citing several documents yields a Citation for each of them.
+	Start, End int
+}
+
+// ExtractCitations returns the citations of the documents in text: markers in
+// square brackets containing one or more comma-separated document IDs.
+// Markers that do not match any document are ignored, so that e.g. Markdown
+// links are not mistaken for citations. docs must be the list passed to
+// ContextMessage.
+func ExtractCitations(text string, docs []Document) []Citation {
+	return extractCitations(text, 0, documentsByID(docs))
+}
+
+func extractCitations(text string, offset int, byID map[string]Document) []Citation {
+	var citations []Citation
+	for _, m := range citationPattern.FindAllStringSubmatchIndex(text, -1) {
+		for _, id := range strings.Split(text[m[2]:m[3]], ",") {
+			if doc, ok := byID[strings.TrimSpace(id)]; ok {
+				citations = append(citations, Citation{Document: doc, Start: offset + m[0], End: offset + m[1]})
+			}
+		}
+	}
+	return citations
+}
+
+// CitedDocuments returns the documents cited in text (see ExtractCitations),
+// each once, in the order of their first citation.
+func CitedDocuments(text string, docs []Document) []Document {
+	var cited []Document
+	seen := make(map[string]bool)
+	for _, c := range ExtractCitations(text, docs) {
+		if !seen[c.Document.ID] {
+			seen[c.Document.ID] = true
+			cited = append(cited, c.Document)
+		}
+	}
+	return cited
+}
+
+// CitationParser extracts citations from text that arrives in pieces, such as
+// a streamed answer. Markers split between pieces are recognized once complete.
+type CitationParser struct {
+	byID    map[string]Document
+	pending string
+	offset  int
+}
+
+// NewCitationParser returns a parser of citations of the documents.
+// docs must be the list passed to ContextMessage.
+func NewCitationParser(docs []Document) *CitationParser {
+	return &CitationParser{byID: documentsByID(docs)}
+}
+
+// Write adds the next piece of text and returns the citations completed by it.
+// The offsets of the citations are relative to the whole text written so far.
+func (p *CitationParser) Write(text string) []Citation {
+	p.pending += text
+
+	// Keep a trailing unclosed marker for the next piece.
+	complete := len(p.pending)
+	if i := strings.LastIndexByte(p.pending, '['); i >= 0 && !strings.ContainsAny(p.pending[i:], "]\n") && len(p.pending)-i < maxCitationMarker {
+		complete = i
+	}
+
+	citations := extractCitations(p.pending[:complete], p.offset, p.byID)
+	p.offset += complete
+	p.pending = p.pending[complete:]
+	return citations
+}
+
+// GenerateWithCitations sends the messages with the documents as context (see
+// ContextMessage), inserted before the last message, and returns the response
+// along with the citations found in the content of its first choice.
+func (g *GenerativeModel) GenerateWithCitations(ctx context.Context, messages []Message, docs []Document, opts ...CallOption) (*CompletionResponse, []Citation, error) {
+	if len(messages) == 0 {
+		return nil, nil, fmt.Errorf("empty message")
+	}
+
+	withContext := make([]Message, 0, len(messages)+1)
+	withContext = append(withContext, messages[:len(messages)-1]...)
+	withContext = append(withContext, ContextMessage(docs...), messages[len(messages)-1])
+
+	resp, err := g.Generate(ctx, withContext, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return resp, nil, nil
+	}
+	return resp, ExtractCitations(resp.Choices[0].Message.Content, docs), nil
+}
+
+func documentsByID(docs []Document) map[string]Document {
+	byID := make(map[string]Document, len(docs))
+	for _, doc := range withDocumentIDs(docs) {
+		byID[doc.ID] = doc
+	}
+	return byID
+}
+
+// withDocumentIDs returns a copy of docs with the default IDs filled in.
+func withDocumentIDs(docs []Document) []Document {
+	out := make([]Document, len(docs))
+	for i, doc := range docs {
+		if doc.ID == "" {
+			doc.ID = strconv.Itoa(i + 1)
+		}
+		out[i] = doc
+	}
+	return out
+}
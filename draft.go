@@ -0,0 +1,156 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultDraftThreshold is the default value of DraftOptions.Threshold.
+const defaultDraftThreshold = 0.7
+
+const draftReviewInstruction = `You review answers of an assistant. Rate how confident you are that the answer below is correct, complete and helpful for the conversation, from 0 (certainly wrong) to 10 (certainly right).
+Reply with the number only.`
+
+const draftReviewPrompt = `Conversation:
+%s
+
+Answer:
+%s`
+
+// draftScorePattern matches the score in the reply of the reviewer.
+var draftScorePattern = regexp.MustCompile(`\d+(?:[.,]\d+)?`)
+
+// DraftPath tells which model served the response of GenerateWithDraft.
+type DraftPath string
+
+const (
+	// DraftPathDraft means the draft of the small model was confident enough.
+	DraftPathDraft DraftPath = "draft"
+	// DraftPathEscalated means the large model answered.
+	DraftPathEscalated DraftPath = "escalated"
+)
+
+// DraftOptions configures GenerateWithDraft.
+type DraftOptions struct {
+	// Threshold is the confidence (0-1) below which the request is escalated
+	// to the large model. Defaults to 0.7.
+	Threshold float64
+
+	// Confidence, if set, rates the draft from 0 to 1 instead of the default
+	// self-review, in which the small model grades its own answer. The API
+	// does not return log probabilities, so heuristics are prompt-based.
+	Confidence func(ctx context.Context, messages []Message, draft *CompletionResponse) (float64, error)
+}
+
+// DraftResult is the result of GenerateWithDraft.
+type DraftResult struct {
+	// Response is the response served: the draft or the answer of the large model.
+	Response *CompletionResponse
+
+	// Path tells which model served Response.
+	Path DraftPath
+
+	// Confidence is the confidence in the draft, 0 if it was escalated
+	// without being rated.
+	Confidence float64
+
+	// Draft is the response of the small model.
+	Draft *CompletionResponse
+}
+
+// GenerateWithDraft answers with the model, usually a small and cheap one, and
+// escalates to large only if the draft is not good enough: if it was truncated,
+// blocked, refused, or rated below opts.Threshold. It returns which path served
+// the response, so the savings can be measured. CallOptions apply to both answers.
+//
+// Rating the draft costs a request to the small model, unless opts.Confidence is set.
+func (g *GenerativeModel) GenerateWithDraft(ctx context.Context, messages []Message, large *GenerativeModel, opts DraftOptions, callOpts ...CallOption) (*DraftResult, error) {
+	if large == nil {
+		return nil, errors.New("large model is nil")
+	}
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = defaultDraftThreshold
+	}
+
+	draft, err := g.Generate(ctx, messages, callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("draft failed: %w", err)
+	}
+	result := &DraftResult{Draft: draft}
+
+	if usableDraft(draft) {
+		confidence := opts.Confidence
+		if confidence == nil {
+			confidence = g.reviewDraft
+		}
+		result.Confidence, err = confidence(ctx, messages, draft)
+		if err != nil {
+			g.c.logf("failed to rate draft, escalating: %v", err)
+			result.Confidence = 0
+		}
+		if result.Confidence >= threshold {
+			result.Response = draft
+			result.Path = DraftPathDraft
+			return result, nil
+		}
+	}
+
+	resp, err := large.Generate(ctx, messages, callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("escalation failed: %w", err)
+	}
+	result.Response = resp
+	result.Path = DraftPathEscalated
+	return result, nil
+}
+
+// usableDraft reports whether the draft is worth rating.
+func usableDraft(resp *CompletionResponse) bool {
+	if len(resp.Choices) == 0 || resp.Refusal {
+		return false
+	}
+	switch resp.Choices[0].FinishReason {
+	case FinishReasonLength, FinishReasonBlacklist, FinishReasonError:
+		return false
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content) != "" || resp.Choices[0].Message.FunctionCall != nil
+}
+
+// reviewDraft asks the model to rate its own draft from 0 to 10 and returns
+// the rating scaled to 0-1.
+func (g *GenerativeModel) reviewDraft(ctx context.Context, messages []Message, draft *CompletionResponse) (float64, error) {
+	var conversation strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&conversation, "%s: %s\n", m.Role, m.Content)
+	}
+
+	reviewer := g.withSystemInstruction(draftReviewInstruction)
+	reviewer.examples = nil
+	reviewer.Functions = nil
+	reviewer.FunctionCall = ""
+	reviewer.Temperature = 0
+
+	prompt := fmt.Sprintf(draftReviewPrompt, strings.TrimSpace(conversation.String()), draft.Choices[0].Message.Content)
+	resp, err := reviewer.Generate(ctx, []Message{{Role: RoleUser, Content: prompt}})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Choices) == 0 {
+		return 0, errors.New("review has no choices")
+	}
+
+	match := draftScorePattern.FindString(resp.Choices[0].Message.Content)
+	if match == "" {
+		return 0, fmt.Errorf("review has no score: %q", resp.Choices[0].Message.Content)
+	}
+	score, err := strconv.ParseFloat(strings.Replace(match, ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+	return min(score, 10) / 10, nil
+}
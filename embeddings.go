@@ -0,0 +1,167 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// DefaultEmbeddingsModel is the model used by Client.Embedder if none is given.
+const DefaultEmbeddingsModel = "Embeddings"
+
+// Embedding is the vector representation of a text.
+type Embedding struct {
+	// Index is the position of the text in the request.
+	Index int `json:"index"`
+
+	// Vector is the embedding vector.
+	Vector []float32 `json:"embedding"`
+
+	// Usage is the token usage of the text.
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+type embeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data  []Embedding `json:"data"`
+	Model string      `json:"model"`
+}
+
+// Dimensions returns the number of dimensions of the embedding vector.
+func (e Embedding) Dimensions() int {
+	return len(e.Vector)
+}
+
+// ModelTypeEmbedder is the ModelInfo.Type of embedding models.
+const ModelTypeEmbedder = "embedder"
+
+// EmbeddingModels returns the embedding models available to the account (see
+// ListModels), to be passed to Embeddings or Embedder.
+func (c *Client) EmbeddingModels(ctx context.Context) ([]ModelInfo, error) {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(models, func(m ModelInfo) bool { return m.Type != ModelTypeEmbedder }), nil
+}
+
+// checkEmbeddingsModel returns an error if the models available to the
+// account do not include model as an embedding model. Models are not checked
+// if they cannot be listed.
+func (c *Client) checkEmbeddingsModel(ctx context.Context, model string) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return nil
+	}
+	i := slices.IndexFunc(models, func(m ModelInfo) bool { return m.ID == model })
+	if i < 0 {
+		return fmt.Errorf("unknown embedding model %q", model)
+	}
+	if t := models[i].Type; t != "" && t != ModelTypeEmbedder {
+		return fmt.Errorf("model %q is not an embedding model but of type %q", model, t)
+	}
+	return nil
+}
+
+// Embeddings returns the embeddings of the texts computed by the given model
+// (e.g. DefaultEmbeddingsModel), using the /embeddings endpoint. The embeddings
+// are returned in the order of the texts. The tokens spent are counted in
+// Client.Stats and Client.UsageSince like those of generation requests.
+//
+// The model is checked against the models available to the account (see
+// EmbeddingModels), whose list is cached by the client, so that a chat model
+// or a typo fails before vectors of an unexpected shape reach an index. All
+// vectors of a call have the same number of dimensions.
+func (c *Client) Embeddings(ctx context.Context, model string, texts ...string) ([]Embedding, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no texts to embed")
+	}
+	if err := c.checkEmbeddingsModel(ctx, model); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(embeddingsRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp embeddingsResponse
+	if err := c.doJSON(ctx, http.MethodPost, c.apiURL("/embeddings"), body, &resp); err != nil {
+		return nil, err
+	}
+
+	var usage UsageStats
+	for _, e := range resp.Data {
+		usage.PromptTokens += e.Usage.PromptTokens
+	}
+	usage.TotalTokens = usage.PromptTokens
+	usedModel := resp.Model
+	if usedModel == "" {
+		usedModel = model
+	}
+	costCenter := costCenterFromContext(ctx)
+	c.stats.recordUsage(costCenter, usage)
+	c.usage.record(time.Now(), costCenter, usedModel, usage)
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	embeddings := make([]Embedding, len(texts))
+	seen := make([]bool, len(texts))
+	for _, e := range resp.Data {
+		if e.Index < 0 || e.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", e.Index)
+		}
+		if seen[e.Index] {
+			return nil, fmt.Errorf("duplicate embedding index %d", e.Index)
+		}
+		if e.Dimensions() != resp.Data[0].Dimensions() {
+			return nil, fmt.Errorf("embeddings have different dimensions: %d and %d", resp.Data[0].Dimensions(), e.Dimensions())
+		}
+		seen[e.Index] = true
+		embeddings[e.Index] = e
+	}
+	return embeddings, nil
+}
+
+// Embedder computes embedding vectors of texts.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderFunc is an adapter to allow the use of ordinary functions as an Embedder.
+type EmbedderFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Embed calls f(ctx, texts).
+func (f EmbedderFunc) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return f(ctx, texts)
+}
+
+// Embedder returns an Embedder computing embeddings with the given model,
+// DefaultEmbeddingsModel if empty.
+func (c *Client) Embedder(model string) Embedder {
+	if model == "" {
+		model = DefaultEmbeddingsModel
+	}
+	return EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+		embeddings, err := c.Embeddings(ctx, model, texts...)
+		if err != nil {
+			return nil, err
+		}
+		vectors := make([][]float32, len(embeddings))
+		for i, e := range embeddings {
+			vectors[i] = e.Vector
+		}
+		return vectors, nil
+	})
+}
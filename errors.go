@@ -0,0 +1,214 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestError describes a failed call to one of the GigaChat endpoints.
+// It carries structured context about the call (endpoint, method, attempt and
+// elapsed time) and wraps the underlying cause, so callers can use errors.As to
+// inspect the context and errors.Is/errors.As to inspect the cause.
+type RequestError struct {
+	// Endpoint is the URL the request was sent to.
+	Endpoint string
+
+	// Method is the HTTP method of the request.
+	Method string
+
+	// Attempt is the 1-based number of the attempt that failed.
+	Attempt int
+
+	// StatusCode is the HTTP status code of the last response, 0 if none was received.
+	StatusCode int
+
+	// Elapsed is the time spent on the call, including all previous attempts.
+	Elapsed time.Duration
+
+	// Err is the underlying error.
+	Err error
+
+	// class is the error class used for statistics (see the ErrorClass constants).
+	class string
+}
+
+// Error implements the error interface.
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s %s (attempt %d, %s): %v", e.Method, e.Endpoint, e.Attempt, e.Elapsed.Round(time.Millisecond), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// newRequestError wraps err with the context of the call it occurred in.
+func newRequestError(method, endpoint string, attempt int, start time.Time, err error) *RequestError {
+	return &RequestError{
+		Endpoint: endpoint,
+		Method:   method,
+		Attempt:  attempt,
+		Elapsed:  time.Since(start),
+		Err:      err,
+	}
+}
+
+// Sentinel errors matched (via errors.Is) by the APIError of a response.
+var (
+	// ErrUnauthorized matches 401 responses: the access token or authorization key was rejected.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited matches 429 responses.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrBadRequest matches 400 and 422 responses: the request is invalid.
+	ErrBadRequest = errors.New("bad request")
+	// ErrModelNotFound matches 404 responses about an unknown model.
+	ErrModelNotFound = errors.New("model not found")
+)
+
+// APIError is an error response of the API. Failed calls return it wrapped in
+// a *RequestError.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Code is the error code given in the response body, if any.
+	Code int
+
+	// Message is the error message given in the response body, if any.
+	Message string
+
+	// RqUID is the ID of the request, to be quoted when contacting support.
+	// It is taken from the X-Request-ID response header, or from the RqUID
+	// header of OAuth requests.
+	RqUID string
+
+	// Body is the raw response body.
+	Body []byte
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// Is reports whether target is the sentinel error matching the response (see
+// ErrUnauthorized, ErrRateLimited, ErrBadRequest and ErrModelNotFound).
+func (e *APIError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return target == ErrBadRequest
+	case http.StatusNotFound:
+		// GigaChat answers "No such model" for unknown models.
+		return target == ErrModelNotFound && strings.Contains(strings.ToLower(e.Message), "model")
+	}
+	return false
+}
+
+// newAPIError returns the error of a response with the given body.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Body: body}
+	var fields struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &fields) == nil {
+		apiErr.Code = fields.Code
+		apiErr.Message = fields.Message
+	}
+	apiErr.RqUID = resp.Header.Get("X-Request-ID")
+	if apiErr.RqUID == "" && resp.Request != nil {
+		apiErr.RqUID = resp.Request.Header.Get("X-Request-ID")
+		if apiErr.RqUID == "" {
+			apiErr.RqUID = resp.Request.Header.Get("RqUID")
+		}
+	}
+	return apiErr
+}
+
+// ErrorCategory tells how a failure can be dealt with.
+type ErrorCategory int
+
+const (
+	// ErrorFatal failures are not expected to succeed on retry nor to be fixed
+	// by changing the request, e.g. an unpaid account or an undecodable response.
+	ErrorFatal ErrorCategory = iota
+	// ErrorRetryable failures are transient and may succeed if the request is
+	// repeated, e.g. network failures, rate limiting and server errors.
+	ErrorRetryable
+	// ErrorUserFixable failures are caused by the request itself, e.g. invalid
+	// parameters, an unknown model or blocked input, and need it to be changed.
+	ErrorUserFixable
+)
+
+// String returns the name of the category.
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorUserFixable:
+		return "user-fixable"
+	}
+	return "fatal"
+}
+
+// statusCategories maps the HTTP status codes returned by the GigaChat API to
+// error categories. Other 4xx codes are user-fixable and other 5xx codes are retryable.
+var statusCategories = map[int]ErrorCategory{
+	http.StatusBadRequest:            ErrorUserFixable, // invalid parameters
+	http.StatusUnauthorized:          ErrorRetryable,   // expired token, refreshed on retry
+	http.StatusPaymentRequired:       ErrorFatal,       // tokens of the account are spent
+	http.StatusForbidden:             ErrorFatal,       // no access to the model or the scope
+	http.StatusNotFound:              ErrorUserFixable, // unknown model or endpoint
+	http.StatusRequestEntityTooLarge: ErrorUserFixable, // the prompt or file is too large
+	http.StatusUnprocessableEntity:   ErrorUserFixable, // invalid message format
+	http.StatusTooManyRequests:       ErrorRetryable,   // rate limited
+	http.StatusNotImplemented:        ErrorFatal,
+}
+
+// Classify returns the category of an error returned by the client.
+// Errors not caused by a request, such as validation errors and blocked input,
+// are user-fixable; cancellation of the context is fatal.
+func Classify(err error) ErrorCategory {
+	if errors.Is(err, context.Canceled) {
+		return ErrorFatal
+	}
+
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		return ErrorUserFixable
+	}
+
+	if code := reqErr.StatusCode; code != 0 {
+		if category, ok := statusCategories[code]; ok {
+			return category
+		}
+		if code >= 500 {
+			return ErrorRetryable
+		}
+		return ErrorUserFixable
+	}
+
+	switch reqErr.class {
+	case ErrorClassTransport, ErrorClassAuth:
+		return ErrorRetryable
+	case ErrorClassLimiter:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrorRetryable
+		}
+	}
+	return ErrorFatal
+}
+
+// Retryable reports whether the request that failed with err may succeed if repeated.
+func Retryable(err error) bool {
+	return err != nil && Classify(err) == ErrorRetryable
+}
@@ -0,0 +1,45 @@
+//go:build examples
+
+// Chat holds a multi-turn conversation with ChatSession against the fake
+// server of gigagotest.
+//
+//	go run -tags examples ./examples/chat
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	model.SystemInstruction = "You are a helpful assistant."
+
+	chat := model.StartChat()
+	for _, text := range []string{"Hi! My name is Anna.", "What is my name?"} {
+		resp, err := chat.SendMessage(ctx, text)
+		if err != nil {
+			log.Fatalf("failed to send message: %v", err)
+		}
+		fmt.Printf("> %s\n%s\n", text, resp.Choices[0].Message.Content)
+	}
+	fmt.Printf("The history holds %d messages.\n", len(chat.History))
+}
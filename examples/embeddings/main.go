@@ -0,0 +1,64 @@
+//go:build examples
+
+// Embeddings computes text embeddings and gives a chat a long-term memory
+// that recalls facts from an earlier conversation.
+//
+//	go run -tags examples ./examples/embeddings
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	embeddings, err := client.Embeddings(ctx, gigago.DefaultEmbeddingsModel, "The cat sleeps", "A dog barks")
+	if err != nil {
+		log.Fatalf("failed to compute embeddings: %v", err)
+	}
+	for _, e := range embeddings {
+		fmt.Printf("text %d: %d dimensions, %d tokens\n", e.Index, len(e.Vector), e.Usage.PromptTokens)
+	}
+
+	memory := gigago.NewInMemoryMemory(client.Embedder(""))
+	model := client.GenerativeModel("GigaChat")
+
+	first := model.StartChat()
+	first.Memory = memory
+	if _, err := first.SendMessage(ctx, "My favourite colour is green."); err != nil {
+		log.Fatalf("failed to send message: %v", err)
+	}
+
+	// A new session, e.g. the next day, recalls the fact.
+	records, err := memory.Recall(ctx, "What is my favourite colour?", 1)
+	if err != nil {
+		log.Fatalf("failed to recall: %v", err)
+	}
+	fmt.Printf("recalled %q (score %.2f)\n", records[0].Text, records[0].Score)
+
+	second := model.StartChat()
+	second.Memory = memory
+	resp, err := second.SendMessage(ctx, "What is my favourite colour?")
+	if err != nil {
+		log.Fatalf("failed to send message: %v", err)
+	}
+	fmt.Println(resp.Choices[0].Message.Content)
+}
@@ -0,0 +1,70 @@
+//go:build examples
+
+// Files uploads, lists, downloads and deletes files.
+//
+//	go run -tags examples ./examples/files
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	file, err := client.UploadFile(ctx, "notes.txt", "text/plain", strings.NewReader("Meeting at 10:00."))
+	if err != nil {
+		log.Fatalf("failed to upload file: %v", err)
+	}
+	fmt.Printf("uploaded %s as %s\n", file.Filename, file.ID)
+
+	files, err := client.ListFiles(ctx)
+	if err != nil {
+		log.Fatalf("failed to list files: %v", err)
+	}
+	for _, f := range files {
+		fmt.Printf("- %s: %s, %d bytes\n", f.ID, f.Filename, f.Bytes)
+	}
+
+	var content bytes.Buffer
+	if err := client.DownloadFileContent(ctx, file.ID, &content); err != nil {
+		log.Fatalf("failed to download file: %v", err)
+	}
+	fmt.Printf("content: %s\n", content.String())
+
+	// Files are attached to messages by ID.
+	model := client.GenerativeModel("GigaChat")
+	resp, err := model.Generate(ctx, []gigago.Message{{
+		Role:        gigago.RoleUser,
+		Content:     "Summarize the attached notes.",
+		Attachments: []string{file.ID},
+	}})
+	if err != nil {
+		log.Fatalf("failed to generate: %v", err)
+	}
+	fmt.Println(resp.Choices[0].Message.Content)
+
+	if err := client.DeleteFile(ctx, file.ID); err != nil {
+		log.Fatalf("failed to delete file: %v", err)
+	}
+	fmt.Println("deleted", file.ID)
+}
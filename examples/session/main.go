@@ -0,0 +1,72 @@
+//go:build examples
+
+// Session saves the history of a conversation to a file and resumes it later,
+// e.g. after a restart of the program.
+//
+//	go run -tags examples ./examples/session
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	path := filepath.Join(os.TempDir(), "gigago-session.json")
+	defer os.Remove(path)
+
+	chat := model.StartChat()
+	chat.MaxHistory = 20
+	if _, err := chat.SendMessage(ctx, "Let's plan a trip to Kazan."); err != nil {
+		log.Fatalf("failed to send message: %v", err)
+	}
+
+	data, err := json.Marshal(chat.History)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		log.Fatalf("failed to save session: %v", err)
+	}
+	fmt.Printf("saved %d messages to %s\n", len(chat.History), path)
+
+	// Later: restore the history into a new session.
+	data, err = os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("failed to load session: %v", err)
+	}
+	resumed := model.StartChat()
+	resumed.MaxHistory = 20
+	if err := json.Unmarshal(data, &resumed.History); err != nil {
+		log.Fatalf("invalid session: %v", err)
+	}
+
+	resp, err := resumed.SendMessage(ctx, "What should we see there?")
+	if err != nil {
+		log.Fatalf("failed to send message: %v", err)
+	}
+	fmt.Println(resp.Choices[0].Message.Content)
+	fmt.Printf("the resumed session holds %d messages\n", len(resumed.History))
+}
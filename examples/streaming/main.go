@@ -0,0 +1,57 @@
+//go:build examples
+
+// Streaming prints the answer of the model piece by piece as it is generated.
+//
+//	go run -tags examples ./examples/streaming
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	messages := []gigago.Message{{Role: gigago.RoleUser, Content: "Tell me a short story about a brave little robot."}}
+
+	stream, err := model.GenerateStream(ctx, messages)
+	if err != nil {
+		log.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("stream failed: %v", err)
+		}
+		for _, choice := range chunk.Choices {
+			fmt.Print(choice.Delta.Content)
+		}
+		if chunk.Usage != nil {
+			fmt.Printf("\n(%d tokens)\n", chunk.Usage.TotalTokens)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//go:build examples
+
+// Tools lets the model call a Go function and answer with its result.
+//
+//	go run -tags examples ./examples/tools
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+)
+
+// weather is the function the model may call.
+func weather(city string) map[string]any {
+	return map[string]any{"city": city, "temperature": 21, "conditions": "sunny"}
+}
+
+func main() {
+	ctx := context.Background()
+
+	server := gigagotest.NewServer()
+	defer server.Close()
+	// The fake model calls the first function for user messages, as a real
+	// model would for a question about the weather.
+	server.Responder = func(r *gigagotest.ChatRequest) gigagotest.Reply {
+		if r.LastMessage().Role == "user" && len(r.Functions) > 0 {
+			return gigagotest.Reply{FunctionCall: &gigagotest.FunctionCall{
+				Name:      r.Functions[0].Name,
+				Arguments: json.RawMessage(`{"city":"Paris"}`),
+			}}
+		}
+		return gigagotest.Echo(r)
+	}
+
+	client, err := gigago.NewClient(ctx, "YOUR_API_KEY",
+		gigago.WithCustomURLAI(server.APIURL),
+		gigago.WithCustomURLOauth(server.OAuthURL),
+	)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	model.Functions = []gigago.Function{{
+		Name:        "weather",
+		Description: "Returns the current weather in a city",
+		Parameters: gigago.FunctionParameters{
+			Properties: map[string]*gigago.Property{
+				"city": {Type: "string", Description: "Name of the city"},
+			},
+			Required: []string{"city"},
+		},
+	}}
+
+	chat := model.StartChat()
+	resp, err := chat.SendMessage(ctx, "What is the weather like in Paris?")
+	if err != nil {
+		log.Fatalf("failed to send message: %v", err)
+	}
+
+	for resp.Choices[0].FinishReason == gigago.FinishReasonFunctionCall {
+		call := resp.Choices[0].Message.FunctionCall
+		var args struct {
+			City string `json:"city"`
+		}
+		if err := json.Unmarshal(call.Arguments, &args); err != nil {
+			log.Fatalf("invalid arguments: %v", err)
+		}
+		fmt.Printf("The model calls %s(%s)\n", call.Name, args.City)
+
+		result, err := gigago.FunctionResultMessage(call.Name, weather(args.City), resp.Choices[0].Message.FunctionsStateID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resp, err = chat.Send(ctx, []gigago.Message{result})
+		if err != nil {
+			log.Fatalf("failed to send function result: %v", err)
+		}
+	}
+	fmt.Println(resp.Choices[0].Message.Content)
+}
@@ -0,0 +1,202 @@
+package gigago
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultExportBatchSize is the default value of EmbeddingExporter.BatchSize.
+const defaultExportBatchSize = 16
+
+// ExportFormat is the output format of an EmbeddingExporter.
+type ExportFormat string
+
+const (
+	// ExportJSONL writes a JSON object per line with the fields id, text,
+	// metadata and embedding.
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportCSV writes a header and a row per text with the columns id, text,
+	// metadata (a JSON object) and embedding (a JSON array).
+	ExportCSV ExportFormat = "csv"
+)
+
+// ExportRecord is a text of a corpus to embed, along with its metadata.
+type ExportRecord struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// exportRow is a line of the JSONL output.
+type exportRow struct {
+	ExportRecord
+	Vector []float32 `json:"embedding"`
+}
+
+// EmbeddingExporter computes the embeddings of a corpus and writes them with
+// their metadata for offline indexing. The requests go through the Embedder,
+// so an Embedder returned by Client.Embedder is subject to the rate limits of
+// the client (see WithRateLimit and WithLimiter).
+//
+// Parquet is not supported, as it would add a dependency to the module; CSV
+// and JSONL files can be converted by most data tools.
+type EmbeddingExporter struct {
+	// Embedder computes the embeddings, e.g. Client.Embedder.
+	Embedder Embedder
+
+	// Format is the output format. Defaults to ExportJSONL.
+	Format ExportFormat
+
+	// BatchSize is the number of texts embedded per request. Defaults to 16.
+	BatchSize int
+
+	// MaxChunkRunes, if positive, splits longer texts into chunks of at most
+	// that many runes, at whitespace where possible. Each chunk is written as
+	// a record with the ID of the text followed by "#" and the 1-based
+	// number of the chunk.
+	MaxChunkRunes int
+}
+
+// Export embeds the records and writes them to w. It returns the number of
+// records written, which are complete batches if it fails midway.
+func (e *EmbeddingExporter) Export(ctx context.Context, w io.Writer, records iter.Seq2[ExportRecord, error]) (int, error) {
+	var write func(exportRow) error
+	flush := func() error { return nil }
+	switch e.Format {
+	case "", ExportJSONL:
+		enc := json.NewEncoder(w)
+		write = func(row exportRow) error { return enc.Encode(row) }
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "text", "metadata", "embedding"}); err != nil {
+			return 0, err
+		}
+		write = func(row exportRow) error {
+			metadata, err := json.Marshal(row.Metadata)
+			if err != nil {
+				return err
+			}
+			vector, err := json.Marshal(row.Vector)
+			if err != nil {
+				return err
+			}
+			return cw.Write([]string{row.ID, row.Text, string(metadata), string(vector)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", e.Format)
+	}
+
+	batchSize := e.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+
+	var written int
+	batch := make([]ExportRecord, 0, batchSize)
+	embed := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		texts := make([]string, len(batch))
+		for i, r := range batch {
+			texts[i] = r.Text
+		}
+		vectors, err := e.Embedder.Embed(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed records %d to %d: %w", written+1, written+len(batch), err)
+		}
+		if len(vectors) != len(batch) {
+			return fmt.Errorf("expected %d embeddings, got %d", len(batch), len(vectors))
+		}
+		for i, r := range batch {
+			if err := write(exportRow{ExportRecord: r, Vector: vectors[i]}); err != nil {
+				return err
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		written += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for record, err := range records {
+		if err != nil {
+			return written, err
+		}
+		chunks := []string{record.Text}
+		if e.MaxChunkRunes > 0 {
+			chunks = chunkText(record.Text, e.MaxChunkRunes)
+		}
+		for i, text := range chunks {
+			chunk := record
+			chunk.Text = text
+			if len(chunks) > 1 {
+				chunk.ID = record.ID + "#" + strconv.Itoa(i+1)
+			}
+			batch = append(batch, chunk)
+			if len(batch) == batchSize {
+				if err := embed(); err != nil {
+					return written, err
+				}
+			}
+		}
+	}
+	if err := embed(); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// LineRecords returns the non-empty lines read from r as records, with the
+// 1-based line numbers as IDs, for use with EmbeddingExporter.Export.
+func LineRecords(r io.Reader) iter.Seq2[ExportRecord, error] {
+	return func(yield func(ExportRecord, error) bool) {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			if !yield(ExportRecord{ID: strconv.Itoa(line), Text: text}, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(ExportRecord{}, err)
+		}
+	}
+}
+
+// chunkText splits text into chunks of at most max runes, breaking at the last
+// whitespace of a chunk where there is one.
+func chunkText(text string, max int) []string {
+	var chunks []string
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > max {
+		end := max
+		for i := max; i > 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				end = i
+				break
+			}
+		}
+		chunks = append(chunks, strings.TrimSpace(string(runes[:end])))
+		runes = []rune(strings.TrimSpace(string(runes[end:])))
+	}
+	return append(chunks, string(runes))
+}
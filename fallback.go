@@ -0,0 +1,90 @@
+package gigago
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// WithStaleFallback provides an Option to serve the last successful response to
+// an identical request when the API is unavailable (network failures and 5xx
+// responses), instead of returning the error. Such responses have Stale set.
+// This suits read-mostly assistant features with availability targets.
+//
+// Up to maxEntries responses are kept in memory; the oldest are evicted first.
+// Requests match only if their model, messages and parameters are identical,
+// ignoring differences removed by CanonicalJSON.
+func WithStaleFallback(maxEntries int) Option {
+	return func(c *Client) {
+		c.fallback = &fallbackCache{max: maxEntries, entries: make(map[[sha256.Size]byte]*CompletionResponse)}
+	}
+}
+
+// fallbackCache keeps the last known good responses by request body.
+// It is safe for concurrent use.
+type fallbackCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[[sha256.Size]byte]*CompletionResponse
+	order   [][sha256.Size]byte
+}
+
+func (f *fallbackCache) put(body []byte, resp *CompletionResponse) {
+	if f.max <= 0 {
+		return
+	}
+	key := requestHash(body)
+	stored := *resp
+	stored.Choices = slices.Clone(resp.Choices)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.entries[key]; !ok {
+		if len(f.order) >= f.max {
+			delete(f.entries, f.order[0])
+			f.order = f.order[1:]
+		}
+		f.order = append(f.order, key)
+	}
+	f.entries[key] = &stored
+}
+
+func (f *fallbackCache) get(body []byte) (*CompletionResponse, bool) {
+	key := requestHash(body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp, ok := f.entries[key]
+	if !ok {
+		return nil, false
+	}
+	stale := *resp
+	stale.Choices = slices.Clone(resp.Choices)
+	return &stale, true
+}
+
+// staleFallback returns the stored response to the request body if err means
+// that the API is unavailable. Calls canceled or timed out by the caller fail.
+func (c *Client) staleFallback(ctx context.Context, body []byte, err error) (*CompletionResponse, bool) {
+	if c.fallback == nil {
+		return nil, false
+	}
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, false
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || (reqErr.class != ErrorClassTransport && reqErr.class != ErrorClassServer) {
+		return nil, false
+	}
+	resp, ok := c.fallback.get(body)
+	if !ok {
+		return nil, false
+	}
+	c.logf("serving stale response: %v", err)
+	resp.Stale = true
+	return resp, true
+}
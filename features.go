@@ -0,0 +1,56 @@
+package gigago
+
+import (
+	"os"
+	"strings"
+)
+
+// Feature names an experimental behavior of the client. Experimental behaviors
+// are disabled by default and can be enabled ahead of becoming defaults, either
+// with the WithFeature option or with the GIGAGODEBUG environment variable,
+// which uses the GODEBUG syntax: GIGAGODEBUG=strictdecoding=1,otherfeature=0.
+type Feature string
+
+const (
+	// FeatureStrictDecoding makes decoding of API responses fail on fields
+	// unknown to this SDK, which helps to detect wire format changes early.
+	FeatureStrictDecoding Feature = "strictdecoding"
+)
+
+// featureEnv is the environment variable holding feature settings.
+const featureEnv = "GIGAGODEBUG"
+
+// WithFeature provides an Option to enable or disable an experimental feature.
+// It takes precedence over the GIGAGODEBUG environment variable.
+func WithFeature(f Feature, enabled bool) Option {
+	return func(c *Client) {
+		if c.features == nil {
+			c.features = make(map[Feature]bool)
+		}
+		c.features[f] = enabled
+	}
+}
+
+// parseFeatures parses GODEBUG-style settings ("name=1,name=0").
+// Malformed entries are ignored, as GODEBUG does.
+func parseFeatures(settings string) map[Feature]bool {
+	features := make(map[Feature]bool)
+	for _, setting := range strings.Split(settings, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok || name == "" {
+			continue
+		}
+		features[Feature(name)] = value == "1"
+	}
+	return features
+}
+
+// featuresFromEnv returns the feature settings from the environment.
+func featuresFromEnv() map[Feature]bool {
+	return parseFeatures(os.Getenv(featureEnv))
+}
+
+// enabled reports whether the feature is enabled for the client.
+func (c *Client) enabled(f Feature) bool {
+	return c.features[f]
+}
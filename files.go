@@ -0,0 +1,355 @@
+package gigago
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultUploadMaxBytes is the default size limit of UploadFromURL downloads.
+	defaultUploadMaxBytes = 40 << 20
+	// defaultUploadTimeout is the default timeout of UploadFromURL downloads.
+	defaultUploadTimeout = 30 * time.Second
+	// maxUploadRedirects is the number of redirects followed by UploadFromURL downloads.
+	maxUploadRedirects = 5
+	// filePurposeGeneral is the purpose of files attached to messages.
+	filePurposeGeneral = "general"
+)
+
+// DefaultUploadContentTypes lists the content types accepted by UploadFromURL by default.
+var DefaultUploadContentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/tiff",
+	"image/bmp",
+	"application/pdf",
+	"text/plain",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/epub+zip",
+	"application/vnd.ms-powerpoint",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// File describes a file stored in GigaChat, e.g. an uploaded attachment or a generated image.
+type File struct {
+	// ID is the identifier of the file, used to attach it to messages.
+	ID string `json:"id"`
+
+	// Object is the type of the API object, always "file".
+	Object string `json:"object"`
+
+	// Bytes is the size of the file.
+	Bytes int64 `json:"bytes"`
+
+	// CreatedAt is the Unix timestamp of when the file was created.
+	CreatedAt int64 `json:"created_at"`
+
+	// Filename is the name of the file.
+	Filename string `json:"filename"`
+
+	// Purpose is the purpose of the file, e.g. "general".
+	Purpose string `json:"purpose"`
+
+	// AccessPolicy is the access policy of the file, "private" or "public".
+	AccessPolicy string `json:"access_policy,omitempty"`
+}
+
+// CreatedTime returns CreatedAt as time.Time.
+func (f *File) CreatedTime() time.Time {
+	return unixTime(f.CreatedAt)
+}
+
+// UploadFile uploads the content read from r as a file to be attached to messages.
+// contentType must be one supported by the API (see DefaultUploadContentTypes).
+func (c *Client) UploadFile(ctx context.Context, filename, contentType string, r io.Reader) (*File, error) {
+	return c.UploadFileWithProgress(ctx, filename, contentType, r, nil)
+}
+
+// UploadFileWithProgress is like UploadFile, but calls progress, if not nil, as
+// the request body is sent with the number of bytes sent so far and the total
+// size of the body, so UIs can show the progress of large uploads. If the
+// request is retried, the count restarts from zero.
+//
+// The API does not support resumable uploads, so a failed upload must be
+// restarted from the beginning.
+func (c *Client) UploadFileWithProgress(ctx context.Context, filename, contentType string, r io.Reader, progress func(sent, total int64)) (*File, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	if err := w.WriteField("purpose", filePurposeGeneral); err != nil {
+		return nil, err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filename}))
+	header.Set("Content-Type", contentType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		ctx = context.WithValue(ctx, uploadProgressKey{}, progress)
+	}
+
+	var file File
+	if err := c.do(ctx, http.MethodPost, c.apiURL("/files"), w.FormDataContentType(), buf.Bytes(), &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// UploadFromURLOptions configures UploadFromURL.
+type UploadFromURLOptions struct {
+	// MaxBytes limits the size of the downloaded file. Defaults to 40 MiB.
+	MaxBytes int64
+
+	// ContentTypes lists the accepted content types. Defaults to DefaultUploadContentTypes.
+	ContentTypes []string
+
+	// Timeout limits the duration of the download. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// HTTPClient is used for the download. Defaults to a client without the
+	// GigaChat-specific TLS settings of the Client that only connects to public
+	// addresses, ignores proxies and follows at most 5 redirects. A custom
+	// client is used as is, so it must apply such restrictions itself if the
+	// URLs come from untrusted users.
+	HTTPClient *http.Client
+
+	// Progress, if set, reports the progress of the upload (see UploadFileWithProgress).
+	Progress func(sent, total int64)
+}
+
+// UploadFromURL downloads a remote image or document and uploads it as a file
+// to be attached to messages, which is a frequent need in bots that receive media links.
+// The download is bounded by the size, content type and timeout limits of opts.
+//
+// Fetching URLs supplied by users from a server exposes the network of the
+// server to them (server-side request forgery). The default HTTP client thus
+// refuses to connect to loopback, private, link-local and unspecified
+// addresses, including after redirects and DNS resolution.
+func (c *Client) UploadFromURL(ctx context.Context, rawURL string, opts UploadFromURLOptions) (*File, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultUploadMaxBytes
+	}
+	contentTypes := opts.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultUploadContentTypes
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultUploadTimeout
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = uploadHTTPClient
+	}
+
+	dlCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(dlCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: unexpected status %d", u.Redacted(), resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("file at %s is too large: %d bytes, limit is %d", u.Redacted(), resp.ContentLength, maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", u.Redacted(), err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("file at %s is too large: limit is %d bytes", u.Redacted(), maxBytes)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !slices.Contains(contentTypes, contentType) {
+		return nil, fmt.Errorf("file at %s has unsupported content type %q", u.Redacted(), contentType)
+	}
+
+	filename := path.Base(u.Path)
+	if filename == "." || filename == "/" || strings.TrimSpace(filename) == "" {
+		filename = "file"
+	}
+
+	return c.UploadFileWithProgress(ctx, filename, contentType, bytes.NewReader(data), opts.Progress)
+}
+
+// uploadHTTPClient is the default HTTP client of UploadFromURL.
+var uploadHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialPublicOnly,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxUploadRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxUploadRedirects)
+		}
+		return nil
+	},
+}
+
+// nonPublicPrefixes are the special-purpose address ranges (RFC 6890) not
+// covered by the netip.Addr predicates used by dialPublicOnly.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation (TEST-NET-1)
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation (TEST-NET-3)
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, may translate to internal IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// dialPublicOnly is a net.Dialer Control function refusing connections to
+// addresses that are not publicly routable. It runs after DNS resolution, so
+// host names resolving to internal addresses are refused too.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to non-public address %s", ip)
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("refusing to connect to non-public address %s", ip)
+		}
+	}
+	return nil
+}
+
+type uploadProgressKey struct{}
+
+// progressReader reports the number of bytes read from r.
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return n, err
+}
+
+type fileList struct {
+	Data []File `json:"data"`
+}
+
+// ListFiles returns the files stored in the account, using the /files endpoint.
+func (c *Client) ListFiles(ctx context.Context) ([]File, error) {
+	var list fileList
+	if err := c.doJSON(ctx, http.MethodGet, c.apiURL("/files"), nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// FilePager returns a Pager over the uploaded files.
+func (c *Client) FilePager() *Pager[File] {
+	return singlePager(c.ListFiles)
+}
+
+// GetFile returns the description of a file, using the /files/{id} endpoint.
+// An unknown file fails with a *RequestError with StatusCode 404.
+func (c *Client) GetFile(ctx context.Context, id string) (*File, error) {
+	var file File
+	if err := c.doJSON(ctx, http.MethodGet, c.apiURL("/files/"+url.PathEscape(id)), nil, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DownloadFileContent writes the content of a file, e.g. a generated image, to
+// w, using the /files/{id}/content endpoint. The content is written only once
+// it is received completely, so nothing is written if the download fails.
+func (c *Client) DownloadFileContent(ctx context.Context, id string, w io.Writer) error {
+	return c.doJSON(ctx, http.MethodGet, c.apiURL("/files/"+url.PathEscape(id)+"/content"), nil, w)
+}
+
+// DeleteFile deletes a file, using the /files/{id}/delete endpoint.
+func (c *Client) DeleteFile(ctx context.Context, id string) error {
+	var resp struct {
+		ID      string `json:"id"`
+		Deleted bool   `json:"deleted"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, c.apiURL("/files/"+url.PathEscape(id)+"/delete"), nil, &resp); err != nil {
+		return err
+	}
+	if !resp.Deleted {
+		return fmt.Errorf("file %s was not deleted", id)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package gigago
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Values of GenerativeModel.FunctionCall besides the name of a function.
+const (
+	// FunctionCallAuto lets the model decide whether to call a function.
+	FunctionCallAuto = "auto"
+	// FunctionCallNone prevents the model from calling functions.
+	FunctionCallNone = "none"
+)
+
+// Function describes a function the model may call, with its parameters
+// given as a JSON schema.
+type Function struct {
+	// Name is the name of the function.
+	Name string `json:"name"`
+
+	// Description tells the model what the function does and when to call it.
+	Description string `json:"description,omitempty"`
+
+	// Parameters describes the arguments of the function.
+	Parameters FunctionParameters `json:"parameters"`
+
+	// FewShotExamples are example requests along with the arguments the
+	// function should be called with, which improve the accuracy of the calls.
+	FewShotExamples []FunctionExample `json:"few_shot_examples,omitempty"`
+
+	// ReturnParameters optionally describes the result of the function.
+	ReturnParameters *FunctionParameters `json:"return_parameters,omitempty"`
+}
+
+// FunctionParameters is the JSON schema of the arguments or the result of a function.
+type FunctionParameters struct {
+	// Type is the type of the schema, "object" if empty.
+	Type string `json:"type"`
+
+	// Properties describe the fields of the object by name.
+	Properties map[string]*Property `json:"properties"`
+
+	// Required lists the fields that must be present.
+	Required []string `json:"required,omitempty"`
+}
+
+// MarshalJSON encodes the parameters, defaulting Type to "object".
+func (p FunctionParameters) MarshalJSON() ([]byte, error) {
+	type plain FunctionParameters
+	if p.Type == "" {
+		p.Type = "object"
+	}
+	if p.Properties == nil {
+		p.Properties = map[string]*Property{}
+	}
+	return json.Marshal(plain(p))
+}
+
+// Property is the JSON schema of a single value.
+type Property struct {
+	// Type is the JSON type of the value: "string", "number", "integer",
+	// "boolean", "array" or "object".
+	Type string `json:"type"`
+
+	// Description tells the model what the value means.
+	Description string `json:"description,omitempty"`
+
+	// Enum lists the allowed values.
+	Enum []string `json:"enum,omitempty"`
+
+	// Items is the schema of the elements of an array.
+	Items *Property `json:"items,omitempty"`
+
+	// Properties describe the fields of an object by name.
+	Properties map[string]*Property `json:"properties,omitempty"`
+
+	// Required lists the fields of an object that must be present.
+	Required []string `json:"required,omitempty"`
+}
+
+// FunctionExample is an example call of a function.
+type FunctionExample struct {
+	// Request is the user request.
+	Request string `json:"request"`
+
+	// Params are the arguments the function should be called with.
+	Params map[string]any `json:"params"`
+}
+
+// functionCallSetting is the encoded form of GenerativeModel.FunctionCall.
+type functionCallSetting string
+
+// MarshalJSON encodes the modes as strings and function names as objects.
+func (s functionCallSetting) MarshalJSON() ([]byte, error) {
+	switch s {
+	case FunctionCallAuto, FunctionCallNone:
+		return json.Marshal(string(s))
+	}
+	return json.Marshal(struct {
+		Name string `json:"name"`
+	}{string(s)})
+}
+
+// FunctionResultMessage returns the message reporting the result of a
+// function call to the model. The result is encoded as JSON. stateID is the
+// FunctionsStateID of the response that requested the call, if any.
+func FunctionResultMessage(name string, result any, stateID string) (Message, error) {
+	content, err := json.Marshal(result)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to encode result of function %s: %w", name, err)
+	}
+	return Message{Role: RoleFunction, Name: name, Content: string(content), FunctionsStateID: stateID}, nil
+}
+
+// validateFunctions reports the problems of the functions and the function
+// call setting of the model.
+func (g *GenerativeModel) validateFunctions(add func(field, format string, args ...any)) {
+	names := make(map[string]bool, len(g.Functions))
+	for i, f := range g.Functions {
+		switch {
+		case f.Name == "":
+			add("functions", "function %d has no name", i)
+		case names[f.Name]:
+			add("functions", "function %s is defined more than once", f.Name)
+		}
+		names[f.Name] = true
+	}
+
+	switch g.FunctionCall {
+	case "", FunctionCallAuto, FunctionCallNone:
+	default:
+		if !names[g.FunctionCall] {
+			add("function_call", "function_call names undefined function %s", g.FunctionCall)
+		}
+	}
+}
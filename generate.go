@@ -1,21 +1,26 @@
 package gigago
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
 type payload struct {
-	Model             string    `json:"model"`
-	Messages          []Message `json:"messages"`
-	Temperature       float64   `json:"temperature"`
-	MaxTokens         int32     `json:"max_tokens"`
-	RepetitionPenalty float64   `json:"repetition_penalty"`
-	TopP              float64   `json:"top_p"`
+	Model             string              `json:"model"`
+	Messages          []Message           `json:"messages"`
+	Temperature       float64             `json:"temperature"`
+	MaxTokens         int32               `json:"max_tokens"`
+	RepetitionPenalty float64             `json:"repetition_penalty"`
+	TopP              float64             `json:"top_p"`
+	Seed              *int64              `json:"seed,omitempty"`
+	Stream            bool                `json:"stream,omitempty"`
+	Functions         []Function          `json:"functions,omitempty"`
+	FunctionCall      functionCallSetting `json:"function_call,omitempty"`
 }
 
 // CompletionResponse represents the entire response from the GigaChat API for a chat completion request.
@@ -34,6 +39,32 @@ type CompletionResponse struct {
 
 	// Object is the type of the API object, typically "chat.completion".
 	Object string `json:"object"`
+
+	// Refusal is true if the model refused to answer, as detected by a heuristic
+	// (see IsRefusal and WithRefusalDetector). It is not part of the API response.
+	Refusal bool `json:"-"`
+
+	// ContextWindow reports how much of the model's context window the request used.
+	// It is computed by the client and is not part of the API response.
+	ContextWindow ContextWindowUsage `json:"-"`
+
+	// Stale is true if the API was unavailable and the response is a stored
+	// answer to an identical earlier request (see WithStaleFallback).
+	Stale bool `json:"-"`
+
+	// Cached is true if the response is the answer to a similar earlier
+	// prompt, served by the cache of WithSemanticCache.
+	Cached bool `json:"-"`
+
+	// Truncated is true if the response was assembled from a stream that
+	// failed before it was complete, e.g. because the deadline of the context
+	// expired (see Stream.Collect). It holds the content received until then.
+	Truncated bool `json:"-"`
+}
+
+// CreatedTime returns Created as time.Time.
+func (r *CompletionResponse) CreatedTime() time.Time {
+	return unixTime(r.Created)
 }
 
 // Choice represents a single completion alternative.
@@ -44,11 +75,30 @@ type Choice struct {
 	// Index is the position of this choice in the list, starting from 0.
 	Index int `json:"index"`
 
-	// FinishReason indicates why the model stopped generating tokens.
-	// Possible values include: "stop", "length", "function_call", "blacklist", "error".
-	FinishReason string `json:"finish_reason"`
+	// FinishReason indicates why the model stopped generating tokens, e.g.
+	// FinishReasonLength if the answer was truncated.
+	FinishReason FinishReason `json:"finish_reason"`
 }
 
+// FinishReason tells why the model stopped generating a choice.
+type FinishReason string
+
+const (
+	// FinishReasonStop means the model completed its answer.
+	FinishReasonStop FinishReason = "stop"
+	// FinishReasonLength means the answer was truncated at the MaxTokens limit
+	// or at the end of the context window.
+	FinishReasonLength FinishReason = "length"
+	// FinishReasonFunctionCall means the message requests a function call (see
+	// ResponseMessage.FunctionCall).
+	FinishReasonFunctionCall FinishReason = "function_call"
+	// FinishReasonBlacklist means the answer was blocked by the content filter
+	// of the API and replaced with a canned reply.
+	FinishReasonBlacklist FinishReason = "blacklist"
+	// FinishReasonError means the generation failed on the side of the API.
+	FinishReasonError FinishReason = "error"
+)
+
 // ResponseMessage represents a message generated by the assistant.
 // It can contain either text content or a request to call a function.
 type ResponseMessage struct {
@@ -60,6 +110,16 @@ type ResponseMessage struct {
 
 	// FunctionCall, if not nil, indicates that the model wants to call a function.
 	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// FunctionsStateID identifies the function call; pass it back in the messages
+	// of the call and its result (see FunctionResultMessage).
+	FunctionsStateID string `json:"functions_state_id,omitempty"`
+}
+
+// Message returns the message as part of the conversation history, to be sent
+// back along with the result of a function call.
+func (m ResponseMessage) Message() Message {
+	return Message{Role: m.Role, Content: m.Content, FunctionCall: m.FunctionCall, FunctionsStateID: m.FunctionsStateID}
 }
 
 // FunctionCall represents a model's request to invoke a specific tool or function.
@@ -91,13 +151,96 @@ type UsageStats struct {
 }
 
 // Generate sends the provided messages to the model and returns a completion.
-// It prepends a system instruction if one is configured on the GenerativeModel.
+// It prepends a system instruction and few-shot examples if they are configured
+// on the GenerativeModel.
 //
 // This method includes a retry mechanism: if the initial request fails with an
 // authentication error (HTTP 401), it will attempt to refresh the access token
 // and retry the request once. An error is returned if the message slice is empty,
-// or if the request fails after the retry attempt.
-func (g *GenerativeModel) Generate(ctx context.Context, message []Message) (*CompletionResponse, error) {
+// or if the request fails after the retry attempt. Failures of the request itself
+// are returned as *RequestError, which can be inspected with errors.As.
+//
+// CallOptions override the model's settings for this call only.
+func (g *GenerativeModel) Generate(ctx context.Context, message []Message, opts ...CallOption) (*CompletionResponse, error) {
+	callOpts := newCallOptions(opts)
+	payload, err := g.prepare(ctx, message, callOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, cacheKey := g.c.semanticLookup(ctx, payload)
+	if cached != nil {
+		return cached, nil
+	}
+
+	var result CompletionResponse
+	if err := g.c.doJSON(ctx, http.MethodPost, g.c.baseURLAI, jsonData, &result); err != nil {
+		if stale, ok := g.c.staleFallback(ctx, jsonData, err); ok {
+			return stale, nil
+		}
+		return nil, err
+	}
+	model := result.Model
+	if model == "" {
+		model = payload.Model
+	}
+
+	costCenter := costCenterFromContext(ctx)
+	g.c.stats.recordUsage(costCenter, result.Usage)
+	g.c.usage.record(time.Now(), costCenter, model, result.Usage)
+
+	if callOpts.prefill != "" {
+		for i := range result.Choices {
+			// A function call answers instead of continuing the prefill.
+			choice := &result.Choices[i]
+			if choice.Message.FunctionCall != nil || choice.FinishReason == FinishReasonFunctionCall {
+				continue
+			}
+			content := &choice.Message.Content
+			if !strings.HasPrefix(*content, callOpts.prefill) {
+				*content = callOpts.prefill + *content
+			}
+		}
+	}
+
+	result.ContextWindow = newContextWindowUsage(model, result.Usage.PromptTokens)
+	result.Refusal = g.c.isRefusal(&result)
+	if w := g.c.contextWindowWarning; w > 0 && result.ContextWindow.Size > 0 && result.ContextWindow.Ratio > w {
+		g.c.logf("request used %d of %d context window tokens of model %s (%.0f%%)",
+			result.ContextWindow.Used, result.ContextWindow.Size, model, result.ContextWindow.Ratio*100)
+	}
+	if g.c.fallback != nil {
+		g.c.fallback.put(jsonData, &result)
+	}
+	g.c.semanticStore(ctx, cacheKey, &result)
+
+	return &result, nil
+}
+
+// prepare validates and moderates the messages and builds the request body
+// for them, as sent by Generate and GenerateStream.
+func (g *GenerativeModel) prepare(ctx context.Context, message []Message, callOpts *callOptions) (*payload, error) {
+	payload, err := g.buildPayload(message, callOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !g.skipModeration {
+		if err := g.c.moderate(ctx, message); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// buildPayload validates the messages and builds the request body for them,
+// without the network checks of prepare.
+func (g *GenerativeModel) buildPayload(message []Message, callOpts *callOptions) (*payload, error) {
 	if len(message) == 0 {
 		return nil, fmt.Errorf("empty message")
 	}
@@ -107,78 +250,68 @@ func (g *GenerativeModel) Generate(ctx context.Context, message []Message) (*Com
 		return nil, fmt.Errorf("invalid model parameters: %w", err)
 	}
 
-	var finalMessages []Message
-	if g.SystemInstruction != "" {
-		finalMessages = make([]Message, 0, len(message)+1)
-		finalMessages = append(finalMessages, Message{Role: RoleSystem, Content: g.SystemInstruction})
-		finalMessages = append(finalMessages, message...)
-	} else {
-		finalMessages = message
+	if err := g.c.validateRoles(message); err != nil {
+		return nil, err
 	}
 
-	payload := payload{
-		Model:             g.fullName,
-		Messages:          finalMessages,
-		Temperature:       g.Temperature,
-		MaxTokens:         g.MaxTokens,
-		RepetitionPenalty: g.RepetitionPenalty,
-		TopP:              g.TopP,
+	if err := g.checkSystemLock(message); err != nil {
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(payload)
+	payload, err := g.newPayload(message, callOpts)
 	if err != nil {
 		return nil, err
 	}
+	payload.Model = g.c.upgradeModel(payload.Model, payload.Messages)
+	return payload, nil
+}
 
-	var resp *http.Response
-
-	for attempt := 0; attempt < 2; attempt++ {
-		var token string
-		g.c.mu.RLock()
-		token = g.c.accessToken.AccessToken
-		g.c.mu.RUnlock()
-
-		req, err := http.NewRequestWithContext(ctx, "POST", g.c.baseURLAI, bytes.NewReader(jsonData))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		resp, err = g.c.httpClient.Do(req)
+// newPayload builds the request body for the messages: it adds the system
+// instruction, the locale directive, the few-shot examples and the prefill,
+// and applies the call options.
+func (g *GenerativeModel) newPayload(message []Message, callOpts *callOptions) (*payload, error) {
+	systemInstruction := g.SystemInstruction
+	if g.Locale != "" {
+		directive, err := localeDirective(g.Locale)
 		if err != nil {
-			return nil, fmt.Errorf("request failed: %w", err)
+			return nil, err
 		}
+		systemInstruction = strings.TrimSpace(systemInstruction + "\n\n" + directive)
+	}
 
-		if resp.StatusCode != http.StatusUnauthorized {
-			break
+	var finalMessages []Message
+	if systemInstruction != "" || len(g.examples) > 0 {
+		finalMessages = make([]Message, 0, len(message)+len(g.examples)+1)
+		if systemInstruction != "" {
+			finalMessages = append(finalMessages, Message{Role: RoleSystem, Content: systemInstruction})
 		}
+		finalMessages = append(finalMessages, g.examples...)
+		finalMessages = append(finalMessages, message...)
+	} else {
+		finalMessages = message
+	}
 
-		resp.Body.Close()
-		resp = nil
-
-		if attempt == 0 {
-			if err := g.c.refreshToken(ctx); err != nil {
-				return nil, fmt.Errorf("failed to refresh token after 401: %w", err)
-			}
+	if callOpts.prefill != "" {
+		if message[len(message)-1].Role == RoleAssistant {
+			return nil, fmt.Errorf("prefill requires the conversation to end with a non-assistant message")
 		}
+		finalMessages = append(slices.Clip(finalMessages), Message{Role: RoleAssistant, Content: callOpts.prefill})
 	}
 
-	if resp == nil {
-		return nil, fmt.Errorf("no response received after retries")
+	payload := &payload{
+		Model:             g.fullName,
+		Messages:          finalMessages,
+		Temperature:       g.Temperature,
+		MaxTokens:         g.MaxTokens,
+		RepetitionPenalty: g.RepetitionPenalty,
+		TopP:              g.TopP,
+		Seed:              g.Seed,
+		Functions:         g.Functions,
+		FunctionCall:      functionCallSetting(g.FunctionCall),
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		var result CompletionResponse
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return nil, err
-		}
-		return &result, nil
+	if callOpts.seed != nil {
+		payload.Seed = callOpts.seed
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-	return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	return payload, nil
 }
@@ -0,0 +1,362 @@
+package gigagotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// EmbeddingSize is the length of the embedding vectors returned by a Server.
+const EmbeddingSize = 64
+
+// serverToken is the access token issued by a Server.
+const serverToken = "gigagotest-token"
+
+// Message is a message of a ChatRequest.
+type Message struct {
+	Role         string        `json:"role"`
+	Content      string        `json:"content"`
+	Name         string        `json:"name,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+	Attachments  []string      `json:"attachments,omitempty"`
+}
+
+// Function is a function defined in a ChatRequest.
+type Function struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// FunctionCall is a call of a function requested by the model.
+type FunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ChatRequest is a chat completions request received by a Server.
+type ChatRequest struct {
+	Model     string     `json:"model"`
+	Messages  []Message  `json:"messages"`
+	Functions []Function `json:"functions,omitempty"`
+	Stream    bool       `json:"stream,omitempty"`
+}
+
+// LastMessage returns the last message of the request, or a zero Message if there is none.
+func (r *ChatRequest) LastMessage() Message {
+	if len(r.Messages) == 0 {
+		return Message{}
+	}
+	return r.Messages[len(r.Messages)-1]
+}
+
+// Reply is the answer of the model to a ChatRequest: either text or a function call.
+type Reply struct {
+	Content      string
+	FunctionCall *FunctionCall
+}
+
+// Echo is the default Responder of a Server. It repeats the last user message,
+// or reports the result of a function call.
+func Echo(r *ChatRequest) Reply {
+	last := r.LastMessage()
+	if last.Role == "function" {
+		return Reply{Content: fmt.Sprintf("The function %s returned %s.", last.Name, last.Content)}
+	}
+	return Reply{Content: "You said: " + last.Content}
+}
+
+// Server is an in-process fake of the GigaChat API for examples and tests of
+// code built on the gigago SDK. It issues access tokens and serves chat
+// completions (streamed or not), embeddings, models and files, keeping the
+// files in memory. Pass OAuthURL and APIURL to gigago.WithCustomURLOauth and
+// gigago.WithCustomURLAI.
+type Server struct {
+	// URL is the root URL of the server.
+	URL string
+
+	// OAuthURL is the URL of the token endpoint.
+	OAuthURL string
+
+	// APIURL is the URL of the chat completions endpoint.
+	APIURL string
+
+	// Responder answers chat completions requests. Defaults to Echo. It must
+	// be set before requests are sent.
+	Responder func(r *ChatRequest) Reply
+
+	server *httptest.Server
+
+	mu     sync.Mutex
+	files  map[string]*storedFile
+	nextID int
+}
+
+type storedFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+
+	data []byte
+}
+
+// NewServer starts a Server. It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{files: make(map[string]*storedFile)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth", s.serveToken)
+	mux.HandleFunc("POST /api/v1/chat/completions", s.authorized(s.serveCompletion))
+	mux.HandleFunc("POST /api/v1/embeddings", s.authorized(s.serveEmbeddings))
+	mux.HandleFunc("GET /api/v1/models", s.authorized(s.serveModels))
+	mux.HandleFunc("POST /api/v1/files", s.authorized(s.serveUpload))
+	mux.HandleFunc("GET /api/v1/files", s.authorized(s.serveFileList))
+	mux.HandleFunc("GET /api/v1/files/{id}", s.authorized(s.serveFile))
+	mux.HandleFunc("GET /api/v1/files/{id}/content", s.authorized(s.serveFileContent))
+	mux.HandleFunc("POST /api/v1/files/{id}/delete", s.authorized(s.serveFileDelete))
+
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	s.OAuthURL = s.URL + "/oauth"
+	s.APIURL = s.URL + "/api/v1/chat/completions"
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") {
+		writeError(w, http.StatusUnauthorized, "Authorization key is missing")
+		return
+	}
+	writeJSON(w, map[string]any{
+		"access_token": serverToken,
+		"expires_at":   time.Now().Add(30 * time.Minute).UnixMilli(),
+	})
+}
+
+// authorized rejects requests without the access token issued by the server.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+serverToken {
+			writeError(w, http.StatusUnauthorized, "Token has expired")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) serveCompletion(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "messages are empty")
+		return
+	}
+
+	respond := s.Responder
+	if respond == nil {
+		respond = Echo
+	}
+	reply := respond(&req)
+
+	finishReason := "stop"
+	if reply.FunctionCall != nil {
+		finishReason = "function_call"
+	}
+	var prompt strings.Builder
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+		prompt.WriteByte(' ')
+	}
+	usage := Usage{PromptTokens: countWords(prompt.String()), CompletionTokens: countWords(reply.Content)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	if req.Stream {
+		if reply.FunctionCall != nil {
+			writeError(w, http.StatusBadRequest, "gigagotest does not stream function calls")
+			return
+		}
+		stream := &SSEStream{Model: req.Model + ":latest"}
+		for _, word := range strings.SplitAfter(reply.Content, " ") {
+			stream.Delta(word)
+		}
+		stream.Finish(finishReason, usage).Done()
+		stream.ServeHTTP(w, r)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"choices": []map[string]any{{
+			"message": map[string]any{
+				"role":          "assistant",
+				"content":       reply.Content,
+				"function_call": reply.FunctionCall,
+			},
+			"index":         0,
+			"finish_reason": finishReason,
+		}},
+		"created": time.Now().Unix(),
+		"model":   req.Model + ":latest",
+		"object":  "chat.completion",
+		"usage":   usage,
+	})
+}
+
+func (s *Server) serveEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data := make([]map[string]any, len(req.Input))
+	for i, text := range req.Input {
+		data[i] = map[string]any{
+			"object":    "embedding",
+			"embedding": embed(text),
+			"index":     i,
+			"usage":     map[string]int{"prompt_tokens": countWords(text)},
+		}
+	}
+	writeJSON(w, map[string]any{"object": "list", "data": data, "model": req.Model})
+}
+
+func (s *Server) serveModels(w http.ResponseWriter, r *http.Request) {
+	var data []map[string]string
+	for _, id := range []string{"GigaChat", "GigaChat-Pro", "GigaChat-Max", "Embeddings"} {
+		data = append(data, map[string]string{"id": id, "object": "model", "owned_by": "salutedevices"})
+	}
+	writeJSON(w, map[string]any{"object": "list", "data": data})
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	file := &storedFile{
+		ID:        fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID),
+		Object:    "file",
+		Bytes:     len(data),
+		CreatedAt: time.Now().Unix(),
+		Filename:  header.Filename,
+		Purpose:   r.FormValue("purpose"),
+		data:      data,
+	}
+	s.files[file.ID] = file
+	s.mu.Unlock()
+
+	writeJSON(w, file)
+}
+
+func (s *Server) serveFileList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	files := make([]*storedFile, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(files, func(a, b *storedFile) int { return strings.Compare(a.ID, b.ID) })
+	writeJSON(w, map[string]any{"data": files})
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	if f := s.file(w, r); f != nil {
+		writeJSON(w, f)
+	}
+}
+
+func (s *Server) serveFileContent(w http.ResponseWriter, r *http.Request) {
+	if f := s.file(w, r); f != nil {
+		w.Header().Set("Content-Type", http.DetectContentType(f.data))
+		w.Write(f.data)
+	}
+}
+
+func (s *Server) serveFileDelete(w http.ResponseWriter, r *http.Request) {
+	f := s.file(w, r)
+	if f == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.files, f.ID)
+	s.mu.Unlock()
+	writeJSON(w, map[string]any{"id": f.ID, "deleted": true})
+}
+
+// file returns the file named in the request path, or writes a 404 response
+// and returns nil if there is none.
+func (s *Server) file(w http.ResponseWriter, r *http.Request) *storedFile {
+	s.mu.Lock()
+	f := s.files[r.PathValue("id")]
+	s.mu.Unlock()
+	if f == nil {
+		writeError(w, http.StatusNotFound, "No such file")
+	}
+	return f
+}
+
+// embed returns a bag-of-words vector of text: texts sharing words have
+// similar embeddings, which is enough to exercise semantic search.
+func embed(text string) []float32 {
+	vector := make([]float32, EmbeddingSize)
+	for _, word := range words(text) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%EmbeddingSize]++
+	}
+	return vector
+}
+
+// words returns the lowercased words of text.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func countWords(text string) int {
+	return len(words(text))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "message": message})
+}
@@ -0,0 +1,78 @@
+package gigagotest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	server := gigagotest.NewServer()
+	defer server.Close()
+
+	client, err := gigago.NewClient(t.Context(), "FakeKey",
+		gigago.WithCustomURLAI(server.APIURL), gigago.WithCustomURLOauth(server.OAuthURL))
+	require.NoError(t, err)
+	defer client.Close()
+	model := client.GenerativeModel("GigaChat")
+	messages := []gigago.Message{{Role: gigago.RoleUser, Content: "Hello there"}}
+
+	resp, err := model.Generate(t.Context(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, "You said: Hello there", resp.Choices[0].Message.Content)
+	assert.Equal(t, "GigaChat:latest", resp.Model)
+	assert.Equal(t, 6, resp.Usage.TotalTokens)
+
+	stream, err := model.GenerateStream(t.Context(), messages)
+	require.NoError(t, err)
+	var content string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content += chunk.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "You said: Hello there", content)
+
+	server.Responder = func(r *gigagotest.ChatRequest) gigagotest.Reply {
+		if r.LastMessage().Role == "user" && len(r.Functions) > 0 {
+			return gigagotest.Reply{FunctionCall: &gigagotest.FunctionCall{Name: r.Functions[0].Name, Arguments: json.RawMessage(`{"city":"Paris"}`)}}
+		}
+		return gigagotest.Echo(r)
+	}
+	model.Functions = []gigago.Function{{Name: "weather"}}
+	resp, err = model.Generate(t.Context(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, gigago.FinishReasonFunctionCall, resp.Choices[0].FinishReason)
+	assert.Equal(t, "weather", resp.Choices[0].Message.FunctionCall.Name)
+
+	embeddings, err := client.Embeddings(t.Context(), gigago.DefaultEmbeddingsModel, "red apple", "apple red", "blue sky")
+	require.NoError(t, err)
+	assert.Len(t, embeddings[0].Vector, gigagotest.EmbeddingSize)
+	assert.Equal(t, embeddings[0].Vector, embeddings[1].Vector)
+	assert.NotEqual(t, embeddings[0].Vector, embeddings[2].Vector)
+
+	models, err := client.ListModels(t.Context())
+	require.NoError(t, err)
+	assert.NotEmpty(t, models)
+
+	file, err := client.UploadFile(t.Context(), "note.txt", "text/plain", bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	files, err := client.ListFiles(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	var data bytes.Buffer
+	require.NoError(t, client.DownloadFileContent(t.Context(), file.ID, &data))
+	assert.Equal(t, "hello", data.String())
+	require.NoError(t, client.DeleteFile(t.Context(), file.ID))
+	_, err = client.GetFile(t.Context(), file.ID)
+	assert.Error(t, err)
+}
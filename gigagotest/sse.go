@@ -0,0 +1,166 @@
+// Package gigagotest provides helpers for testing code built on the gigago SDK
+// against realistic GigaChat wire behavior.
+package gigagotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Usage is the token usage reported in the final chunk of a stream.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type delta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type choice struct {
+	Delta        delta  `json:"delta"`
+	Index        int    `json:"index"`
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+type chunk struct {
+	Choices []choice `json:"choices"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Object  string   `json:"object"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+// SSEStream scripts a GigaChat chat completions response in server-sent events
+// format. Frames are added with the builder methods and the stream is served
+// by ServeHTTP, so an *SSEStream can be used directly as the handler of an
+// httptest.Server.
+//
+// An SSEStream must not be modified while it is being served.
+type SSEStream struct {
+	// Model is reported in every chunk. Defaults to "GigaChat:latest".
+	Model string
+
+	// Created is reported in every chunk. Defaults to the current time.
+	Created int64
+
+	// ChunkSize, if positive, splits the wire bytes into writes of at most
+	// ChunkSize bytes, each flushed separately, so frames arrive split at
+	// arbitrary positions as they do behind some proxies.
+	ChunkSize int
+
+	// Delay is the pause before each write.
+	Delay time.Duration
+
+	// CRLF makes the stream use "\r\n" line endings instead of "\n".
+	CRLF bool
+
+	frames   []string
+	sentRole bool
+}
+
+// NewSSEStream returns an empty stream.
+func NewSSEStream() *SSEStream {
+	return &SSEStream{}
+}
+
+// Delta adds a chunk carrying a piece of the assistant's message.
+func (s *SSEStream) Delta(content string) *SSEStream {
+	d := delta{Content: content}
+	if !s.sentRole {
+		d.Role = "assistant"
+		s.sentRole = true
+	}
+	return s.chunk(chunk{Choices: []choice{{Delta: d}}})
+}
+
+// Finish adds the final chunk with the finish reason (e.g. "stop" or "length") and the token usage.
+func (s *SSEStream) Finish(reason string, usage Usage) *SSEStream {
+	return s.chunk(chunk{Choices: []choice{{FinishReason: reason}}, Usage: &usage})
+}
+
+// Done adds the terminating "data: [DONE]" frame.
+func (s *SSEStream) Done() *SSEStream {
+	return s.Raw("data: [DONE]")
+}
+
+// KeepAlive adds a comment frame, as sent by proxies to keep idle connections open.
+func (s *SSEStream) KeepAlive() *SSEStream {
+	return s.Raw(": keep-alive")
+}
+
+// Error adds an error frame with the given status and message.
+func (s *SSEStream) Error(status int, message string) *SSEStream {
+	data, _ := json.Marshal(map[string]any{"status": status, "message": message})
+	return s.Raw("event: error\ndata: " + string(data))
+}
+
+// Raw adds a frame as is. The frame terminator (an empty line) is added automatically.
+func (s *SSEStream) Raw(frame string) *SSEStream {
+	s.frames = append(s.frames, frame)
+	return s
+}
+
+func (s *SSEStream) chunk(c chunk) *SSEStream {
+	c.Model = s.Model
+	if c.Model == "" {
+		c.Model = "GigaChat:latest"
+	}
+	c.Created = s.Created
+	if c.Created == 0 {
+		c.Created = time.Now().Unix()
+	}
+	c.Object = "chat.completion"
+	data, _ := json.Marshal(c)
+	return s.Raw("data: " + string(data))
+}
+
+// Bytes returns the stream as it is written on the wire.
+func (s *SSEStream) Bytes() []byte {
+	var sb strings.Builder
+	for _, frame := range s.frames {
+		sb.WriteString(frame)
+		sb.WriteString("\n\n")
+	}
+	out := sb.String()
+	if s.CRLF {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	return []byte(out)
+}
+
+// ServeHTTP writes the stream as an event-stream response.
+func (s *SSEStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	data := s.Bytes()
+	size := s.ChunkSize
+	if size <= 0 {
+		size = len(data)
+	}
+
+	for len(data) > 0 {
+		if s.Delay > 0 {
+			select {
+			case <-time.After(s.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		n := min(size, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		data = data[n:]
+	}
+}
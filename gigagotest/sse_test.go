@@ -0,0 +1,43 @@
+package gigagotest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSEStream(t *testing.T) {
+	stream := &SSEStream{Model: "GigaChat:1.0", Created: 1700000000, ChunkSize: 7}
+	stream.Delta("Hel").KeepAlive().Delta("lo").Finish("stop", Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}).Done()
+
+	want := `data: {"choices":[{"delta":{"role":"assistant","content":"Hel"},"index":0}],"created":1700000000,"model":"GigaChat:1.0","object":"chat.completion"}
+
+: keep-alive
+
+data: {"choices":[{"delta":{"content":"lo"},"index":0}],"created":1700000000,"model":"GigaChat:1.0","object":"chat.completion"}
+
+data: {"choices":[{"delta":{"content":""},"index":0,"finish_reason":"stop"}],"created":1700000000,"model":"GigaChat:1.0","object":"chat.completion","usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}
+
+data: [DONE]
+
+`
+	assert.Equal(t, want, string(stream.Bytes()))
+
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, want, string(body))
+
+	crlf := (&SSEStream{CRLF: true}).Error(500, "boom")
+	assert.Equal(t, "event: error\r\ndata: {\"message\":\"boom\",\"status\":500}\r\n\r\n", string(crlf.Bytes()))
+}
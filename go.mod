@@ -2,10 +2,7 @@ module github.com/Role1776/gigago
 
 go 1.24.1
 
-require (
-	github.com/google/uuid v1.6.0
-	github.com/stretchr/testify v1.10.0
-)
+require github.com/stretchr/testify v1.10.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,42 @@
+package gigago
+
+import (
+	"context"
+	"net/http"
+)
+
+type headersKey struct{}
+
+// ContextWithHeader returns a copy of ctx that makes requests issued with it
+// send the given header in addition to the headers configured on the client.
+// It may be applied several times. The headers set by the client itself
+// (e.g. Authorization) cannot be overridden.
+func ContextWithHeader(ctx context.Context, key, value string) context.Context {
+	headers := make(http.Header)
+	if parent, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		headers = parent.Clone()
+	}
+	headers.Set(key, value)
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// ContextWithAcceptLanguage returns a copy of ctx that makes requests issued
+// with it send the Accept-Language header, e.g. "ru-RU" or "en-US, en;q=0.8",
+// which gateways may use to choose the default language of answers.
+func ContextWithAcceptLanguage(ctx context.Context, language string) context.Context {
+	return ContextWithHeader(ctx, "Accept-Language", language)
+}
+
+// ContextWithSessionID returns a copy of ctx that makes requests issued with it
+// send the X-Session-ID header. Requests of the same session are routed so that
+// the API can reuse the cached prompt prefix of earlier requests, which is
+// reported in UsageStats.PrecachedPromptTokens.
+func ContextWithSessionID(ctx context.Context, id string) context.Context {
+	return ContextWithHeader(ctx, "X-Session-ID", id)
+}
+
+// headersFromContext returns the headers stored in ctx, if any.
+func headersFromContext(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(headersKey{}).(http.Header)
+	return headers
+}
@@ -0,0 +1,68 @@
+package gigago
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// imageTagPattern matches the markup of an image generated by the built-in
+// text2image function, e.g. <img src="file-id" fuse="true"/>.
+var imageTagPattern = regexp.MustCompile(`<img\s[^>]*src="([^"]+)"`)
+
+// GeneratedImage is an image generated by GenerateImage.
+type GeneratedImage struct {
+	// FileID is the ID of the image file, which can be passed to
+	// Client.DownloadFileContent or attached to messages.
+	FileID string
+
+	// Data is the content of the image.
+	Data []byte
+
+	// ContentType is the type of the image, detected from Data (e.g. "image/jpeg").
+	ContentType string
+
+	// Response is the response of the model, whose content holds the image markup.
+	Response *CompletionResponse
+}
+
+// GenerateImage asks the model to draw an image described by prompt with the
+// built-in text2image function and downloads it. Function calling is enabled in
+// auto mode for the request, without the Functions of the model. The model may
+// still answer with text only, e.g. to refuse, in which case an error quoting
+// the answer is returned. With WithKeyBalancing, the image is generated and
+// downloaded with the same key.
+func (g *GenerativeModel) GenerateImage(ctx context.Context, prompt string, opts ...CallOption) (*GeneratedImage, error) {
+	// The image is only visible to the account that generated it.
+	ctx = g.c.pinnedContext(ctx)
+
+	model := *g
+	model.Functions = nil
+	model.FunctionCall = FunctionCallAuto
+
+	resp, err := model.Generate(ctx, []Message{{Role: RoleUser, Content: prompt}}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("response has no choices")
+	}
+	content := resp.Choices[0].Message.Content
+	match := imageTagPattern.FindStringSubmatch(content)
+	if match == nil {
+		return nil, fmt.Errorf("response contains no image: %q", content)
+	}
+
+	var data bytes.Buffer
+	if err := g.c.DownloadFileContent(ctx, match[1], &data); err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	return &GeneratedImage{
+		FileID:      match[1],
+		Data:        data.Bytes(),
+		ContentType: imageType(data.Bytes()),
+		Response:    resp,
+	}, nil
+}
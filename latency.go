@@ -0,0 +1,132 @@
+package gigago
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyAlertKind tells which budget of a LatencyBudget was exceeded.
+type LatencyAlertKind string
+
+const (
+	// LatencyFirstToken means the first chunk took longer than LatencyBudget.FirstToken.
+	LatencyFirstToken LatencyAlertKind = "first_token"
+	// LatencyChunkGap means the next chunk took longer than LatencyBudget.ChunkGap.
+	LatencyChunkGap LatencyAlertKind = "chunk_gap"
+)
+
+// LatencyAlert describes an exceeded latency budget of a stream.
+type LatencyAlert struct {
+	// Kind tells which budget was exceeded.
+	Kind LatencyAlertKind
+
+	// Budget is the exceeded budget.
+	Budget time.Duration
+
+	// Chunks is the number of chunks received so far.
+	Chunks int
+}
+
+// LatencyBudget configures WithLatencyBudget. A zero duration disables the
+// corresponding budget.
+type LatencyBudget struct {
+	// FirstToken is the time allowed from the call to the first chunk.
+	FirstToken time.Duration
+
+	// ChunkGap is the time allowed between two chunks.
+	ChunkGap time.Duration
+
+	// OnExceeded is called when a budget is exceeded, at most once per wait, so
+	// that the consumer can show a "still thinking" indicator or switch to a
+	// fallback answer (e.g. by canceling the stream's context). It is called
+	// from another goroutine while Recv may be blocked and must not block.
+	OnExceeded func(LatencyAlert)
+}
+
+// WithLatencyBudget provides a CallOption to watch the latency of a stream
+// opened by GenerateStream. Unlike WithStreamIdleTimeout, exceeding the budget
+// does not fail the stream, and keep-alive comments do not count as chunks.
+// It has no effect on other calls.
+func WithLatencyBudget(budget LatencyBudget) CallOption {
+	return func(o *callOptions) {
+		o.latency = &budget
+	}
+}
+
+// latencyWatch fires the alerts of a LatencyBudget for a stream.
+type latencyWatch struct {
+	budget LatencyBudget
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	gen     int
+	chunks  int
+	stopped bool
+}
+
+// newLatencyWatch starts watching the time to the first chunk, or returns nil
+// if budget is nil or has no callback.
+func newLatencyWatch(budget *LatencyBudget) *latencyWatch {
+	if budget == nil || budget.OnExceeded == nil {
+		return nil
+	}
+	w := &latencyWatch{budget: *budget}
+	w.mu.Lock()
+	w.arm(LatencyFirstToken, budget.FirstToken)
+	w.mu.Unlock()
+	return w
+}
+
+// arm schedules an alert of kind after d, replacing the pending one. The
+// caller must hold w.mu.
+func (w *latencyWatch) arm(kind LatencyAlertKind, d time.Duration) {
+	w.gen++
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if d <= 0 {
+		return
+	}
+	gen := w.gen
+	w.timer = time.AfterFunc(d, func() { w.fire(gen, kind, d) })
+}
+
+// fire reports the alert armed as gen unless it was replaced or stopped meanwhile.
+func (w *latencyWatch) fire(gen int, kind LatencyAlertKind, budget time.Duration) {
+	w.mu.Lock()
+	if w.stopped || w.gen != gen {
+		w.mu.Unlock()
+		return
+	}
+	alert := LatencyAlert{Kind: kind, Budget: budget, Chunks: w.chunks}
+	w.timer = nil
+	w.mu.Unlock()
+
+	w.budget.OnExceeded(alert)
+}
+
+// chunk records a received chunk and starts watching the gap to the next one.
+func (w *latencyWatch) chunk() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	w.chunks++
+	w.arm(LatencyChunkGap, w.budget.ChunkGap)
+}
+
+// stop cancels the pending alert.
+func (w *latencyWatch) stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.arm("", 0)
+}
@@ -0,0 +1,101 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of outgoing API requests. Every HTTP request to the
+// GigaChat API, including retries, waits for the limiter first; OAuth requests do not.
+//
+// Implementations may be distributed (e.g. backed by Redis), so that a fleet of
+// processes collectively respects one account-level quota. Implementations must
+// be safe for concurrent use.
+type Limiter interface {
+	// Wait blocks until a request may be sent. It returns an error if the
+	// request must not be sent, e.g. because ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// LimiterFunc is an adapter to allow the use of ordinary functions as a Limiter.
+type LimiterFunc func(ctx context.Context) error
+
+// Wait calls f(ctx).
+func (f LimiterFunc) Wait(ctx context.Context) error {
+	return f(ctx)
+}
+
+// WithLimiter provides an Option to limit the rate of outgoing API requests with l.
+func WithLimiter(l Limiter) Option {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+// WithRateLimit provides an Option to limit outgoing API requests to rps per
+// second on average, allowing bursts of up to burst requests, so that
+// concurrent workers stay within the quota of the account instead of being
+// throttled with 429s. It is a shorthand for WithLimiter with a token bucket
+// local to the client; use WithLimiter to share a quota across processes.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(c *Client) {
+		if rps <= 0 {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithRateLimit: rps must be positive, got %v", rps))
+			return
+		}
+		c.limiter = newRateLimiter(rps, max(burst, 1))
+	}
+}
+
+// rateLimiter is a token bucket Limiter.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rps, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Wait takes a token from the bucket, waiting until it is refilled if needed.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the reserved token back to the requests still waiting.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token at now and returns how long to wait until it is
+// available. The bucket goes into debt, so waiting requests are served in order.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
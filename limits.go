@@ -0,0 +1,101 @@
+package gigago
+
+import "strings"
+
+// contextWindows maps model names to the size of their context window in tokens.
+var contextWindows = map[string]int{
+	"GigaChat":       32768,
+	"GigaChat-Plus":  32768,
+	"GigaChat-Pro":   32768,
+	"GigaChat-Max":   32768,
+	"GigaChat-2":     131072,
+	"GigaChat-2-Pro": 131072,
+	"GigaChat-2-Max": 131072,
+}
+
+// contextWindowSize returns the context window of the model in tokens, or 0 if
+// it is unknown. Version suffixes reported by the API ("GigaChat:1.0.26.20")
+// and the "-preview" suffix are ignored.
+func contextWindowSize(model string) int {
+	name, _, _ := strings.Cut(model, ":")
+	name = strings.TrimSuffix(name, "-preview")
+	return contextWindows[name]
+}
+
+// ContextWindowUsage describes how much of the model's context window a request used.
+type ContextWindowUsage struct {
+	// Used is the number of prompt tokens of the request.
+	Used int
+
+	// Size is the context window of the model in tokens, 0 if the model is unknown.
+	Size int
+
+	// Ratio is Used divided by Size, 0 if the model is unknown.
+	Ratio float64
+}
+
+// newContextWindowUsage computes the context window usage of a request with the given prompt size.
+func newContextWindowUsage(model string, promptTokens int) ContextWindowUsage {
+	usage := ContextWindowUsage{Used: promptTokens, Size: contextWindowSize(model)}
+	if usage.Size > 0 {
+		usage.Ratio = float64(usage.Used) / float64(usage.Size)
+	}
+	return usage
+}
+
+// WithContextWindowWarning provides an Option to log a warning whenever a request
+// uses more than the given fraction (0-1) of the model's context window, e.g. 0.8.
+// This helps to catch prompts that are about to be truncated.
+func WithContextWindowWarning(threshold float64) Option {
+	return func(c *Client) {
+		c.contextWindowWarning = threshold
+	}
+}
+
+// DefaultModelUpgrades maps the first-generation models to the second-generation
+// models with a larger context window. It can be passed to WithModelUpgrades.
+var DefaultModelUpgrades = map[string]string{
+	"GigaChat":     "GigaChat-2",
+	"GigaChat-Pro": "GigaChat-2-Pro",
+	"GigaChat-Max": "GigaChat-2-Max",
+}
+
+// WithModelUpgrades provides an Option to switch to a model with a larger context
+// window instead of failing when the prompt does not fit into the context window
+// of the requested model. upgrades maps a model to the model it may be replaced
+// with (see DefaultModelUpgrades); only the listed substitutions are made, and
+// each is logged.
+//
+// The prompt size is estimated from its length, so prompts close to the limit
+// may not be upgraded.
+func WithModelUpgrades(upgrades map[string]string) Option {
+	return func(c *Client) {
+		c.modelUpgrades = upgrades
+	}
+}
+
+// upgradeModel returns the model to use for a prompt of the given messages.
+func (c *Client) upgradeModel(model string, messages []Message) string {
+	if len(c.modelUpgrades) == 0 {
+		return model
+	}
+
+	var tokens int
+	for _, m := range messages {
+		tokens += estimateTokens(m.Content)
+	}
+
+	original := model
+	for range len(c.modelUpgrades) {
+		size := contextWindowSize(model)
+		next, ok := c.modelUpgrades[model]
+		if size == 0 || tokens <= size || !ok {
+			break
+		}
+		model = next
+	}
+	if model != original {
+		c.logf("prompt of about %d tokens exceeds the context window of model %s, using %s", tokens, original, model)
+	}
+	return model
+}
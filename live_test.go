@@ -0,0 +1,182 @@
+//go:build live
+
+package gigago
+
+// Conformance tests against the real GigaChat API.
+//
+// They are excluded from regular runs and must be enabled explicitly:
+//
+//	GIGACHAT_API_KEY=... go test -tags live -run Live ./...
+//
+// GIGACHAT_SCOPE selects the OAuth scope (GIGACHAT_API_PERS by default) and
+// GIGACHAT_INSECURE=1 disables certificate verification for environments without
+// the Russian Ministry of Digital root CA. Prompts are kept short to save quota.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLiveClient(t *testing.T, apiKey string) (*Client, error) {
+	t.Helper()
+
+	opts := []Option{WithCustomInsecureSkipVerify(os.Getenv("GIGACHAT_INSECURE") == "1")}
+	if scope := os.Getenv("GIGACHAT_SCOPE"); scope != "" {
+		opts = append(opts, WithCustomScope(scope))
+	}
+	return NewClient(t.Context(), apiKey, opts...)
+}
+
+func liveAPIKey(t *testing.T) string {
+	t.Helper()
+
+	apiKey := os.Getenv("GIGACHAT_API_KEY")
+	if apiKey == "" {
+		t.Skip("GIGACHAT_API_KEY is not set")
+	}
+	return apiKey
+}
+
+func TestLive_Auth(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	require.NotNil(t, client.accessToken)
+	assert.NotEmpty(t, client.accessToken.AccessToken)
+	assert.Positive(t, client.accessToken.ExpiresAt)
+}
+
+func TestLive_AuthInvalidKey(t *testing.T) {
+	liveAPIKey(t)
+
+	_, err := newLiveClient(t, "aW52YWxpZDppbnZhbGlk")
+	var reqErr *RequestError
+	require.ErrorAs(t, err, &reqErr)
+	assert.True(t, strings.Contains(reqErr.Error(), "status 401") || strings.Contains(reqErr.Error(), "status 400"), reqErr.Error())
+}
+
+func TestLive_Generate(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	model.MaxTokens = 16
+	model.SystemInstruction = "Answer with a single word."
+
+	resp, err := model.Generate(t.Context(), []Message{{Role: RoleUser, Content: "Capital of France?"}})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Choices)
+	assert.NotEmpty(t, resp.Choices[0].Message.Content)
+	assert.Equal(t, RoleAssistant, resp.Choices[0].Message.Role)
+	assert.NotEmpty(t, resp.Choices[0].FinishReason)
+	assert.NotEmpty(t, resp.Model)
+	assert.Positive(t, resp.Created)
+	assert.Positive(t, resp.Usage.PromptTokens)
+	assert.Positive(t, resp.Usage.TotalTokens)
+}
+
+func TestLive_GenerateUnknownModel(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.GenerativeModel("GigaChat-DoesNotExist").Generate(t.Context(), []Message{{Role: RoleUser, Content: "Hi"}})
+	var reqErr *RequestError
+	require.ErrorAs(t, err, &reqErr)
+	assert.Contains(t, reqErr.Err.Error(), "unexpected status 404")
+}
+
+func TestLive_GenerateStream(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	model := client.GenerativeModel("GigaChat")
+	model.MaxTokens = 16
+	model.SystemInstruction = "Answer with a single word."
+
+	stream, err := model.GenerateStream(t.Context(), []Message{{Role: RoleUser, Content: "Capital of France?"}})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	var content strings.Builder
+	var finishReason FinishReason
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	assert.NotEmpty(t, content.String())
+	assert.NotEmpty(t, finishReason)
+}
+
+func TestLive_Embeddings(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	embeddings, err := client.Embeddings(t.Context(), DefaultEmbeddingsModel, "cat", "dog")
+	require.NoError(t, err)
+	require.Len(t, embeddings, 2)
+	for i, e := range embeddings {
+		assert.Equal(t, i, e.Index)
+		assert.NotEmpty(t, e.Vector)
+	}
+	assert.Len(t, embeddings[1].Vector, len(embeddings[0].Vector))
+}
+
+func TestLive_Files(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	file, err := client.UploadFile(t.Context(), "gigago-live.txt", "text/plain", strings.NewReader("gigago live test"))
+	require.NoError(t, err)
+	require.NotEmpty(t, file.ID)
+	deleted := false
+	defer func() {
+		if !deleted {
+			client.DeleteFile(context.WithoutCancel(t.Context()), file.ID)
+		}
+	}()
+	assert.Equal(t, "gigago-live.txt", file.Filename)
+
+	files, err := client.ListFiles(t.Context())
+	require.NoError(t, err)
+	assert.True(t, slices.ContainsFunc(files, func(f File) bool { return f.ID == file.ID }), "the uploaded file must be listed")
+
+	require.NoError(t, client.DeleteFile(t.Context(), file.ID))
+	deleted = true
+}
+
+func TestLive_GetUnknownFile(t *testing.T) {
+	client, err := newLiveClient(t, liveAPIKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.GetFile(t.Context(), "00000000-0000-0000-0000-000000000000")
+	var reqErr *RequestError
+	require.ErrorAs(t, err, &reqErr)
+	assert.GreaterOrEqual(t, reqErr.StatusCode, 400)
+	assert.Less(t, reqErr.StatusCode, 500)
+}
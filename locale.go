@@ -0,0 +1,110 @@
+package gigago
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// localeFormat describes the number and date conventions of a locale.
+type localeFormat struct {
+	// decimal and thousands are the decimal and digit group separators.
+	decimal, thousands string
+	// directive is appended to the system instruction.
+	directive string
+}
+
+// localeFormats lists the locales supported by GenerativeModel.Locale.
+var localeFormats = map[string]localeFormat{
+	"ru-RU": {
+		decimal:   ",",
+		thousands: " ",
+		directive: "Форматируй числа по правилам ru-RU: десятичный разделитель — запятая, разряды разделяются пробелом (1 234,56). Даты пиши в формате ДД.ММ.ГГГГ.",
+	},
+	"en-US": {
+		decimal:   ".",
+		thousands: ",",
+		directive: "Format numbers using en-US conventions: the decimal separator is a period and digit groups are separated by commas (1,234.56). Write dates as MM/DD/YYYY.",
+	},
+}
+
+// localeDirective returns the system instruction directive of the locale.
+func localeDirective(locale string) (string, error) {
+	f, ok := localeFormats[locale]
+	if !ok {
+		return "", fmt.Errorf("unsupported locale %q", locale)
+	}
+	return f.directive, nil
+}
+
+// decimalNumberPattern matches numbers with one fractional separator, e.g. "3.14" or "3,14".
+var decimalNumberPattern = regexp.MustCompile(`\d+(?:[.,]\d+)+`)
+
+// CheckLocaleNumbers returns the numbers in text that use a decimal separator
+// that is wrong for the locale, e.g. "3.14" for ru-RU or "3,14" for en-US.
+// Numbers whose separator may be a digit group separator ("1,000") and dates
+// ("01.02.2024") are not reported.
+func CheckLocaleNumbers(text, locale string) ([]string, error) {
+	f, ok := localeFormats[locale]
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale %q", locale)
+	}
+
+	wrong := "."
+	if f.decimal == "." {
+		wrong = ","
+	}
+
+	var found []string
+	for _, number := range decimalNumberPattern.FindAllString(text, -1) {
+		if strings.Count(number, ".")+strings.Count(number, ",") != 1 || !strings.Contains(number, wrong) {
+			continue
+		}
+		_, fraction, _ := strings.Cut(number, wrong)
+		if wrong == f.thousands && len(fraction) == 3 {
+			continue
+		}
+		found = append(found, number)
+	}
+	return found, nil
+}
+
+// CheckLocaleJSON is like CheckLocaleNumbers, but checks only the string values
+// of a JSON document, as JSON numbers always use a period.
+func CheckLocaleJSON(data []byte, locale string) ([]string, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	var found []string
+	var walk func(v any) error
+	walk = func(v any) error {
+		switch val := v.(type) {
+		case string:
+			numbers, err := CheckLocaleNumbers(val, locale)
+			if err != nil {
+				return err
+			}
+			found = append(found, numbers...)
+		case []any:
+			for _, child := range val {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case map[string]any:
+			for _, child := range val {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(v); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
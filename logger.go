@@ -0,0 +1,20 @@
+package gigago
+
+import "log"
+
+// WithLogger provides an Option to set the logger used for warnings and
+// background errors (e.g. failed token refreshes). Defaults to log.Default().
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// logf writes a message prefixed with "gigago: " to the client's logger.
+func (c *Client) logf(format string, args ...any) {
+	logger := c.logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("gigago: "+format, args...)
+}
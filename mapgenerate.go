@@ -0,0 +1,134 @@
+package gigago
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultMapConcurrency is the number of concurrent requests used by MapGenerate by default.
+const defaultMapConcurrency = 4
+
+// Prompt is a single input item of MapGenerate.
+type Prompt struct {
+	// Messages are the messages sent for this item.
+	Messages []Message
+
+	// Config overrides the model's generation parameters for this item.
+	Config GenerationConfig
+}
+
+// MapResult is the result of a single item of MapGenerate.
+type MapResult struct {
+	// Index is the position of the item in the input slice.
+	Index int
+
+	// Response is the completion, nil if Err is set.
+	Response *CompletionResponse
+
+	// Err is the error of the last attempt, if all attempts failed.
+	Err error
+}
+
+// MapOptions configures MapGenerate.
+type MapOptions struct {
+	// Concurrency is the maximum number of requests in flight. Defaults to 4.
+	Concurrency int
+
+	// Retries is the number of additional attempts for a failed item.
+	Retries int
+
+	// RetryDelay is the pause before each retry.
+	RetryDelay time.Duration
+
+	// OnProgress, if set, is called after each item completes with the number
+	// of completed items and the total number of items.
+	OnProgress func(done, total int)
+}
+
+// MapGenerate runs the model over a list of prompts with bounded concurrency
+// and returns the results in input order.
+//
+// Each item may override generation parameters through its Config. Failed items
+// are retried up to opts.Retries times if the error is retryable (see Retryable)
+// and the context is not done.
+//
+// If handler is not nil, it is called with each result in input order as soon as
+// the result and all results before it are available, which allows writing
+// results out while later items are still being processed. Calls to handler
+// and opts.OnProgress are serialized.
+func (g *GenerativeModel) MapGenerate(ctx context.Context, items []Prompt, handler func(MapResult), opts MapOptions) []MapResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultMapConcurrency
+	}
+
+	results := make([]MapResult, len(items))
+	ready := make([]bool, len(items))
+	next, done := 0, 0
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := g.mapItem(ctx, i, item, opts)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[i] = result
+			ready[i] = true
+			done++
+			if opts.OnProgress != nil {
+				opts.OnProgress(done, len(items))
+			}
+			for next < len(items) && ready[next] {
+				if handler != nil {
+					handler(results[next])
+				}
+				next++
+			}
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// mapItem generates the completion of a single MapGenerate item, with retries.
+func (g *GenerativeModel) mapItem(ctx context.Context, index int, item Prompt, opts MapOptions) MapResult {
+	model := *g
+	item.Config.apply(&model)
+
+	result := MapResult{Index: index}
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 && opts.RetryDelay > 0 {
+			select {
+			case <-time.After(opts.RetryDelay):
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				return result
+			}
+		}
+
+		result.Response, result.Err = model.Generate(ctx, item.Messages)
+		if result.Err == nil || !mapRetryable(ctx, result.Err) {
+			return result
+		}
+	}
+	return result
+}
+
+// mapRetryable reports whether a failed MapGenerate item may succeed on retry.
+func mapRetryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && Retryable(err)
+}
@@ -0,0 +1,204 @@
+package gigago
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRecord is a fact remembered by a Memory.
+type MemoryRecord struct {
+	// ID identifies the record. Records stored with an existing ID replace it.
+	// A random ID is assigned if empty.
+	ID string `json:"id"`
+
+	// Text is the remembered fact.
+	Text string `json:"text"`
+
+	// Metadata holds arbitrary attributes of the record, e.g. the user it is about.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// CreatedAt is the time the record was stored.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Score is the similarity of the record to the query, set by Recall.
+	Score float64 `json:"score,omitempty"`
+}
+
+// Memory is the long-term memory of an assistant: facts stored in one
+// conversation and recalled by relevance in later ones. The history of
+// ChatSession is its short-term counterpart.
+type Memory interface {
+	// Store remembers the records.
+	Store(ctx context.Context, records ...MemoryRecord) error
+
+	// Recall returns at most limit records most relevant to the query, most
+	// relevant first.
+	Recall(ctx context.Context, query string, limit int) ([]MemoryRecord, error)
+}
+
+// VectorRecord is a MemoryRecord along with its embedding vector, as kept by a VectorStore.
+type VectorRecord struct {
+	MemoryRecord
+	Vector []float32
+}
+
+// VectorStore stores records by their embedding vectors, e.g. in a vector
+// database. It is the storage behind the Memory returned by NewVectorMemory.
+type VectorStore interface {
+	// Upsert stores the records, replacing records with the same IDs.
+	Upsert(ctx context.Context, records []VectorRecord) error
+
+	// Query returns at most limit records nearest to the vector, nearest first,
+	// with their Score set.
+	Query(ctx context.Context, vector []float32, limit int) ([]VectorRecord, error)
+}
+
+// NewVectorMemory returns a Memory that embeds records and queries with the
+// embedder and keeps the records in store.
+func NewVectorMemory(embedder Embedder, store VectorStore) Memory {
+	return &vectorMemory{embedder: embedder, store: store}
+}
+
+// NewInMemoryMemory returns a Memory that keeps the records in process memory,
+// ranked by the cosine similarity of their embeddings. It is suited for tests
+// and small assistants; its records are lost when the process exits.
+func NewInMemoryMemory(embedder Embedder) Memory {
+	return NewVectorMemory(embedder, NewInMemoryVectorStore())
+}
+
+type vectorMemory struct {
+	embedder Embedder
+	store    VectorStore
+}
+
+func (m *vectorMemory) Store(ctx context.Context, records ...MemoryRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	texts := make([]string, len(records))
+	for i, r := range records {
+		texts[i] = r.Text
+	}
+	vectors, err := m.embedder.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed records: %w", err)
+	}
+	if len(vectors) != len(records) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(records), len(vectors))
+	}
+
+	now := time.Now()
+	stored := make([]VectorRecord, len(records))
+	for i, r := range records {
+		if r.ID == "" {
+			r.ID = newRqUID()
+		}
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = now
+		}
+		r.Score = 0
+		stored[i] = VectorRecord{MemoryRecord: r, Vector: vectors[i]}
+	}
+	return m.store.Upsert(ctx, stored)
+}
+
+func (m *vectorMemory) Recall(ctx context.Context, query string, limit int) ([]MemoryRecord, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	vectors, err := m.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+	}
+
+	found, err := m.store.Query(ctx, vectors[0], limit)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]MemoryRecord, len(found))
+	for i, r := range found {
+		records[i] = r.MemoryRecord
+	}
+	return records, nil
+}
+
+// InMemoryVectorStore is a VectorStore kept in process memory, searched
+// exhaustively by cosine similarity. It is safe for concurrent use.
+type InMemoryVectorStore struct {
+	mu      sync.RWMutex
+	records map[string]VectorRecord
+}
+
+// NewInMemoryVectorStore returns an empty InMemoryVectorStore.
+func NewInMemoryVectorStore() *InMemoryVectorStore {
+	return &InMemoryVectorStore{records: make(map[string]VectorRecord)}
+}
+
+// Upsert implements VectorStore.
+func (s *InMemoryVectorStore) Upsert(ctx context.Context, records []VectorRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		s.records[r.ID] = r
+	}
+	return nil
+}
+
+// Query implements VectorStore.
+func (s *InMemoryVectorStore) Query(ctx context.Context, vector []float32, limit int) ([]VectorRecord, error) {
+	s.mu.RLock()
+	found := make([]VectorRecord, 0, len(s.records))
+	for _, r := range s.records {
+		r.Score = cosineSimilarity(vector, r.Vector)
+		found = append(found, r)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(found, func(a, b VectorRecord) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return found[:min(limit, len(found))], nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, 0 if
+// their lengths differ or one of them is zero.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// memoryMessage returns the message presenting recalled records to the model.
+func memoryMessage(records []MemoryRecord) Message {
+	var sb strings.Builder
+	sb.WriteString("Facts remembered from previous conversations, use them if relevant:\n")
+	for _, r := range records {
+		sb.WriteString("- ")
+		sb.WriteString(r.Text)
+		sb.WriteByte('\n')
+	}
+	return Message{Role: RoleUser, Content: strings.TrimSuffix(sb.String(), "\n")}
+}
@@ -1,11 +1,25 @@
 package gigago
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultMaxTokens is the default MaxTokens value. It leaves the limit to the API.
+const defaultMaxTokens = 999999999
 
 type GenerativeModel struct {
 	c                 *Client
 	fullName          string
 	SystemInstruction string
+	// examples are few-shot messages inserted between the system instruction and the conversation.
+	examples []Message
+	// skipModeration disables input moderation, used by the moderation check itself.
+	skipModeration bool
+	// systemLock is the hash of the system instruction pinned by LockSystemInstruction, if any.
+	systemLock *[sha256.Size]byte
 	// Nucleus sampling (top-p). Limits token selection to the smallest set whose total probability is ≥ top_p (range: 0.0–1.0). Default: 1
 	TopP float64
 	// Sampling temperature. Higher values = more randomness, lower = more deterministic output. Default: 0
@@ -14,6 +28,24 @@ type GenerativeModel struct {
 	MaxTokens int32
 	// Penalizes repeated tokens. Values > 1.0 discourage repetition (1.0 = no penalty). Default 1
 	RepetitionPenalty float64
+	// Locale ("ru-RU" or "en-US"), if set, adds a directive to the system instruction
+	// asking the model to format numbers and dates by the conventions of the locale. Default: ""
+	Locale string
+	// Seed for sampling. Requests with the same seed and parameters are expected to produce
+	// the same output, if supported by the API. Default: nil (not sent)
+	Seed *int64
+	// Functions the model may call. A response calling one has FinishReasonFunctionCall
+	// as its finish reason and the call in ResponseMessage.FunctionCall. Default: nil
+	Functions []Function
+	// FunctionCall controls function calling: FunctionCallAuto, FunctionCallNone or the
+	// name of a function in Functions the model must call. Default: "" (auto if Functions are set)
+	FunctionCall string
+	// StructuredOutput selects how GenerateInto makes the model answer in JSON.
+	// Default: StructuredOutputAuto
+	StructuredOutput StructuredOutputStrategy
+	// ChunkMiddleware transforms the chunks of GenerateStream, in order, before Recv
+	// returns them, e.g. to mask words or convert markdown incrementally. Default: nil
+	ChunkMiddleware []ChunkMiddleware
 }
 
 // GenerativeModel returns a new GenerativeModel instance for the specified model name (e.g., "GigaChat").
@@ -30,24 +62,118 @@ func (c *Client) GenerativeModel(name string) *GenerativeModel {
 		SystemInstruction: "",
 		Temperature:       0,
 		TopP:              1,
-		MaxTokens:         999999999,
+		MaxTokens:         defaultMaxTokens,
 		RepetitionPenalty: 1,
 	}
 }
 
-// Validate checks if the model parameters are within acceptable ranges
+// FieldError describes a single invalid model parameter.
+type FieldError struct {
+	// Field is the name of the parameter as sent to the API (e.g. "top_p").
+	Field string
+
+	// Message explains what is wrong with the value.
+	Message string
+}
+
+// ValidationError is returned by Validate and lists every invalid parameter.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// modelNamePattern matches valid model names, e.g. "GigaChat-2-Max" or "GigaChat:1.0.26.20".
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// Validate checks if the model name and parameters are within acceptable ranges.
+// All violations are reported at once in a *ValidationError.
+// MaxTokens is checked against the context window of known models unless it is left at its default.
 func (g *GenerativeModel) Validate() error {
+	var fields []FieldError
+	add := func(field, format string, args ...any) {
+		fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !modelNamePattern.MatchString(g.fullName) {
+		add("model", "model name %q is invalid", g.fullName)
+	}
 	if g.Temperature < 0 || g.Temperature > 2 {
-		return fmt.Errorf("temperature must be between 0 and 2, got %f", g.Temperature)
+		add("temperature", "temperature must be between 0 and 2, got %f", g.Temperature)
 	}
 	if g.TopP < 0 || g.TopP > 1 {
-		return fmt.Errorf("top_p must be between 0 and 1, got %f", g.TopP)
+		add("top_p", "top_p must be between 0 and 1, got %f", g.TopP)
 	}
 	if g.MaxTokens <= 0 {
-		return fmt.Errorf("max_tokens must be positive, got %d", g.MaxTokens)
+		add("max_tokens", "max_tokens must be positive, got %d", g.MaxTokens)
+	} else if size := contextWindowSize(g.fullName); size > 0 && g.MaxTokens != defaultMaxTokens && int(g.MaxTokens) > size {
+		add("max_tokens", "max_tokens must not exceed the context window of %s (%d), got %d", g.fullName, size, g.MaxTokens)
 	}
 	if g.RepetitionPenalty < 0.1 || g.RepetitionPenalty > 2.0 {
-		return fmt.Errorf("repetition_penalty must be between 0.1 and 2.0, got %f", g.RepetitionPenalty)
+		add("repetition_penalty", "repetition_penalty must be between 0.1 and 2.0, got %f", g.RepetitionPenalty)
+	}
+
+	g.validateFunctions(add)
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
 	}
 	return nil
 }
+
+// GenerationConfig holds a set of generation parameters.
+// Nil fields are left unchanged when the config is applied to a model.
+type GenerationConfig struct {
+	Temperature       *float64 `json:"temperature,omitempty"`
+	TopP              *float64 `json:"top_p,omitempty"`
+	MaxTokens         *int32   `json:"max_tokens,omitempty"`
+	RepetitionPenalty *float64 `json:"repetition_penalty,omitempty"`
+	Seed              *int64   `json:"seed,omitempty"`
+}
+
+// apply copies the non-nil parameters of the config onto the model.
+func (cfg GenerationConfig) apply(g *GenerativeModel) {
+	if cfg.Temperature != nil {
+		g.Temperature = *cfg.Temperature
+	}
+	if cfg.TopP != nil {
+		g.TopP = *cfg.TopP
+	}
+	if cfg.MaxTokens != nil {
+		g.MaxTokens = *cfg.MaxTokens
+	}
+	if cfg.RepetitionPenalty != nil {
+		g.RepetitionPenalty = *cfg.RepetitionPenalty
+	}
+	if cfg.Seed != nil {
+		g.Seed = cfg.Seed
+	}
+}
+
+// String returns the non-nil parameters of the config, e.g. "temperature=0.7 top_p=0.9".
+func (cfg GenerationConfig) String() string {
+	var parts []string
+	if cfg.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *cfg.Temperature))
+	}
+	if cfg.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *cfg.TopP))
+	}
+	if cfg.MaxTokens != nil {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", *cfg.MaxTokens))
+	}
+	if cfg.RepetitionPenalty != nil {
+		parts = append(parts, fmt.Sprintf("repetition_penalty=%g", *cfg.RepetitionPenalty))
+	}
+	if cfg.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *cfg.Seed))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,104 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultModelsTTL is the default time the result of ListModels is cached.
+const defaultModelsTTL = 5 * time.Minute
+
+// WithModelsCacheTTL provides an Option to cache the result of ListModels for
+// ttl instead of 5 minutes, so that model checks do not cost a request each.
+// A zero ttl disables the cache. RefreshModels bypasses the cache.
+func WithModelsCacheTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		if ttl < 0 {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithModelsCacheTTL: ttl must not be negative, got %v", ttl))
+			return
+		}
+		c.modelsTTL = ttl
+	}
+}
+
+// modelsCache holds the result of ListModels until expires.
+type modelsCache struct {
+	mu      sync.Mutex
+	models  []ModelInfo
+	expires time.Time
+}
+
+// ModelInfo describes a model available to the account.
+type ModelInfo struct {
+	// ID is the name of the model, as passed to GenerativeModel.
+	ID string `json:"id"`
+
+	// Object is the type of the API object, always "model".
+	Object string `json:"object"`
+
+	// OwnedBy is the owner of the model.
+	OwnedBy string `json:"owned_by"`
+
+	// Type is the kind of the model, e.g. "chat" or "embedder".
+	Type string `json:"type,omitempty"`
+}
+
+type modelList struct {
+	Object string      `json:"object"`
+	Data   []ModelInfo `json:"data"`
+}
+
+// ListModels returns the models available to the account, using the /models
+// endpoint. The result is cached by the client (see WithModelsCacheTTL),
+// except for requests made with ContextWithCredentials.
+func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	if _, ok := c.credentialsFromContext(ctx); c.modelsTTL > 0 && !ok {
+		c.models.mu.Lock()
+		models, expires := c.models.models, c.models.expires
+		c.models.mu.Unlock()
+		if models != nil && time.Now().Before(expires) {
+			return slices.Clone(models), nil
+		}
+	}
+	return c.RefreshModels(ctx)
+}
+
+// RefreshModels is like ListModels, but always requests the models from the
+// API, and updates the cache with them.
+func (c *Client) RefreshModels(ctx context.Context) ([]ModelInfo, error) {
+	var list modelList
+	if err := c.doJSON(ctx, http.MethodGet, c.apiURL("/models"), nil, &list); err != nil {
+		return nil, err
+	}
+	if _, ok := c.credentialsFromContext(ctx); c.modelsTTL > 0 && !ok {
+		c.models.mu.Lock()
+		c.models.models = slices.Clone(list.Data)
+		if c.models.models == nil {
+			c.models.models = []ModelInfo{}
+		}
+		c.models.expires = time.Now().Add(c.modelsTTL)
+		c.models.mu.Unlock()
+	}
+	return list.Data, nil
+}
+
+// ModelPager returns a Pager over the models available to the account.
+func (c *Client) ModelPager() *Pager[ModelInfo] {
+	return singlePager(c.ListModels)
+}
+
+// ModelInfo returns the description of a model, using the /models/{model}
+// endpoint. It can be used to check a model name at startup: an unknown model
+// fails with a *RequestError with StatusCode 404.
+func (c *Client) ModelInfo(ctx context.Context, name string) (*ModelInfo, error) {
+	var info ModelInfo
+	if err := c.doJSON(ctx, http.MethodGet, c.apiURL("/models/"+url.PathEscape(name)), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
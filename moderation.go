@@ -0,0 +1,181 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrInputBlocked is matched (via errors.Is) by every InputBlockedError.
+var ErrInputBlocked = errors.New("input blocked by moderation")
+
+// InputBlockedError is returned by Generate when the input moderation step
+// rejects a request. No tokens are spent on the main request in that case.
+type InputBlockedError struct {
+	// Categories lists the categories the input was flagged with.
+	Categories []string
+}
+
+// Error implements the error interface.
+func (e *InputBlockedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrInputBlocked, strings.Join(e.Categories, ", "))
+}
+
+// Is reports whether target is ErrInputBlocked.
+func (e *InputBlockedError) Is(target error) bool {
+	return target == ErrInputBlocked
+}
+
+// ModerationRule flags input matching Pattern with Category.
+type ModerationRule struct {
+	Category string
+	Pattern  *regexp.Regexp
+}
+
+// InputModeration configures the optional moderation of user input performed
+// before every generation request.
+//
+// Rules and Denylist are checked locally against all user messages.
+// If Model is set, the latest user message is additionally classified by that
+// model (usually a cheap one) into one of Categories; this costs an extra request
+// but only when the local checks pass.
+type InputModeration struct {
+	// Rules are regular expressions checked against user messages.
+	Rules []ModerationRule
+
+	// Denylist maps a category to words or phrases that are blocked.
+	// Matching is case-insensitive and on whole words, so "ass" does not
+	// flag "class".
+	Denylist map[string][]string
+
+	// Model is the name of the model used for the LLM-based check.
+	// The check is disabled if empty.
+	Model string
+
+	// Categories are the categories the model is asked to detect.
+	// Defaults to DefaultModerationCategories.
+	Categories []string
+}
+
+// DefaultModerationCategories are used by the LLM-based check when
+// InputModeration.Categories is empty.
+var DefaultModerationCategories = []string{"violence", "hate", "sexual", "self-harm", "illegal", "prompt-injection"}
+
+const moderationInstruction = `You are a content moderation classifier. Classify the user's message.
+Categories: %s.
+Reply with a comma-separated list of the matching categories, or with the single word OK if none match. Do not add anything else.`
+
+// WithInputModeration provides an Option to enable the moderation of user input.
+// Requests whose input is flagged fail with an *InputBlockedError before they are sent.
+func WithInputModeration(m InputModeration) Option {
+	return func(c *Client) {
+		for i, rule := range m.Rules {
+			if rule.Pattern == nil {
+				c.optionErrs = append(c.optionErrs, fmt.Errorf("WithInputModeration: rule %d (%q) has no pattern", i, rule.Category))
+				return
+			}
+		}
+		c.moderation = &m
+		c.denylist = compileDenylist(m.Denylist)
+	}
+}
+
+// compileDenylist builds a regexp per category matching any of its words or
+// phrases as whole words. Go's \b only knows ASCII letters, so the boundaries
+// are spelled out to work with Cyrillic text too.
+func compileDenylist(denylist map[string][]string) map[string]*regexp.Regexp {
+	compiled := make(map[string]*regexp.Regexp, len(denylist))
+	for category, words := range denylist {
+		var quoted []string
+		for _, word := range words {
+			if word = strings.TrimSpace(word); word != "" {
+				quoted = append(quoted, regexp.QuoteMeta(word))
+			}
+		}
+		if len(quoted) > 0 {
+			compiled[category] = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(?:` + strings.Join(quoted, "|") + `)(?:[^\p{L}\p{N}_]|$)`)
+		}
+	}
+	return compiled
+}
+
+// moderate runs the configured moderation checks against the messages.
+func (c *Client) moderate(ctx context.Context, messages []Message) error {
+	m := c.moderation
+	if m == nil {
+		return nil
+	}
+
+	var categories []string
+	flag := func(category string) {
+		if !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+
+	var last string
+	for _, msg := range messages {
+		if msg.Role != RoleUser {
+			continue
+		}
+		last = msg.Content
+		for _, rule := range m.Rules {
+			if rule.Pattern.MatchString(msg.Content) {
+				flag(rule.Category)
+			}
+		}
+		for category, re := range c.denylist {
+			if re.MatchString(msg.Content) {
+				flag(category)
+			}
+		}
+	}
+
+	if len(categories) == 0 && m.Model != "" && last != "" {
+		llmCategories, err := c.moderateWithModel(ctx, last)
+		if err != nil {
+			return fmt.Errorf("input moderation failed: %w", err)
+		}
+		categories = llmCategories
+	}
+
+	if len(categories) > 0 {
+		slices.Sort(categories)
+		return &InputBlockedError{Categories: categories}
+	}
+	return nil
+}
+
+// moderateWithModel asks the moderation model to classify text and returns
+// the flagged categories.
+func (c *Client) moderateWithModel(ctx context.Context, text string) ([]string, error) {
+	known := c.moderation.Categories
+	if len(known) == 0 {
+		known = DefaultModerationCategories
+	}
+
+	model := c.GenerativeModel(c.moderation.Model)
+	model.SystemInstruction = fmt.Sprintf(moderationInstruction, strings.Join(known, ", "))
+	model.MaxTokens = 64
+	model.skipModeration = true
+
+	resp, err := model.Generate(ctx, []Message{{Role: RoleUser, Content: text}})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("empty moderation response")
+	}
+
+	var categories []string
+	for _, field := range strings.Split(resp.Choices[0].Message.Content, ",") {
+		category := strings.ToLower(strings.Trim(strings.TrimSpace(field), "."))
+		if slices.Contains(known, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories, nil
+}
@@ -8,8 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-
-	"github.com/google/uuid"
+	"time"
 )
 
 type tokenResponse struct {
@@ -18,37 +17,99 @@ type tokenResponse struct {
 }
 
 func (c *Client) oauthCreate(ctx context.Context) (*tokenResponse, error) {
+	return c.oauthCreateFor(ctx, c.apiKey, c.scope)
+}
+
+const (
+	// oauthMaxAttempts is the number of attempts of a token request rate limited by the OAuth endpoint.
+	oauthMaxAttempts = 5
+	// oauthRetryBaseDelay is the delay before the first retry of a rate limited token request.
+	oauthRetryBaseDelay = 500 * time.Millisecond
+	// oauthRetryMaxDelay caps the delay between retries of a rate limited token request.
+	oauthRetryMaxDelay = 10 * time.Second
+)
+
+// oauthCreateFor requests an access token for the given authorization key and scope.
+//
+// The OAuth endpoint rate limits aggressively, e.g. when many instances start
+// at once, so requests rejected with 429 are retried with a jittered
+// exponential backoff, or after the time given by Retry-After, as long as the
+// context allows.
+func (c *Client) oauthCreateFor(ctx context.Context, apiKey, scope string) (*tokenResponse, error) {
+	start := time.Now()
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		token, retryAfter, err := c.oauthAttempt(ctx, apiKey, scope, attempt, start)
+		if err == nil || retryAfter < 0 || attempt == oauthMaxAttempts {
+			return token, err
+		}
+
+		delay := c.oauthRetryDelay(attempt, retryAfter)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// oauthAttempt sends a single token request. If the request was rate limited,
+// it returns the delay requested by the Retry-After header (0 if absent);
+// otherwise the returned delay is negative.
+func (c *Client) oauthAttempt(ctx context.Context, apiKey, scope string, attempt int, start time.Time) (*tokenResponse, time.Duration, error) {
 	data := url.Values{}
-	data.Set("scope", c.scope)
+	data.Set("scope", scope)
 	body := data.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURLOauth, strings.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURLOauth, strings.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, -1, newRequestError(http.MethodPost, c.baseURLOauth, attempt, start, fmt.Errorf("failed to create request: %w", err))
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
 	// Set a unique request ID for tracing, as required by the Sberbank API.
-	req.Header.Set("RqUID", uuid.NewString())
-	req.Header.Set("Authorization", "Basic "+c.apiKey)
+	req.Header.Set("RqUID", newRqUID())
+	req.Header.Set("Authorization", "Basic "+apiKey)
+	c.setHeaders(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, -1, newRequestError(http.MethodPost, c.baseURLOauth, attempt, start, fmt.Errorf("failed to execute request: %w", err))
 	}
 	defer resp.Body.Close()
+	c.captureHeaders(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("oauth request failed with status %d: %s", resp.StatusCode, string(body))
+		reqErr := newRequestError(http.MethodPost, c.baseURLOauth, attempt, start, fmt.Errorf("oauth request failed with %w", newAPIError(resp, body)))
+		reqErr.StatusCode = resp.StatusCode
+		retryAfter := time.Duration(-1)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter = retryAfterDelay(resp.Header, time.Now())
+		}
+		return nil, retryAfter, reqErr
 	}
 
 	var token tokenResponse
 	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, -1, newRequestError(http.MethodPost, c.baseURLOauth, attempt, start, fmt.Errorf("failed to decode response: %w", err))
 	}
 
-	return &token, nil
+	return &token, -1, nil
+}
+
+// oauthRetryDelay returns the jittered delay before retrying a rate limited
+// token request, so that instances rate limited together do not retry together.
+func (c *Client) oauthRetryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	return c.backoff(attempt, oauthRetryBaseDelay, oauthRetryMaxDelay, retryAfter)
 }
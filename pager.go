@@ -0,0 +1,133 @@
+package gigago
+
+import (
+	"context"
+	"io"
+	"iter"
+	"strconv"
+)
+
+// Page is a page of a list returned by the API.
+type Page[T any] struct {
+	// Items are the items of the page.
+	Items []T
+
+	// NextCursor is the cursor of the next page, or "" if this page is the last.
+	NextCursor string
+}
+
+// PageFunc fetches the page of a list starting at cursor, which is "" for the first page.
+// A nil page with a nil error ends the list.
+type PageFunc[T any] func(ctx context.Context, cursor string) (*Page[T], error)
+
+// Pager iterates over the items of a list fetched page by page, hiding whether
+// the API paginates with cursors, offsets, or not at all. Pages are fetched
+// lazily, as items are consumed. A Pager is not safe for concurrent use.
+type Pager[T any] struct {
+	fetch   PageFunc[T]
+	items   []T
+	cursor  string
+	fetched bool
+	err     error
+}
+
+// NewPager returns a Pager over the pages fetched by fetch, following their
+// cursors until a page has no NextCursor.
+func NewPager[T any](fetch PageFunc[T]) *Pager[T] {
+	return &Pager[T]{fetch: fetch}
+}
+
+// NewOffsetPager returns a Pager over a list paginated by offset, fetching
+// pageSize items at a time. A page with fewer than pageSize items is the last.
+func NewOffsetPager[T any](pageSize int, fetch func(ctx context.Context, offset, limit int) ([]T, error)) *Pager[T] {
+	pageSize = max(pageSize, 1)
+	return NewPager(func(ctx context.Context, cursor string) (*Page[T], error) {
+		offset := 0
+		if cursor != "" {
+			var err error
+			if offset, err = strconv.Atoi(cursor); err != nil {
+				return nil, err
+			}
+		}
+		items, err := fetch(ctx, offset, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		page := &Page[T]{Items: items}
+		if len(items) >= pageSize {
+			page.NextCursor = strconv.Itoa(offset + len(items))
+		}
+		return page, nil
+	})
+}
+
+// singlePager returns a Pager over a list the API returns at once.
+func singlePager[T any](list func(ctx context.Context) ([]T, error)) *Pager[T] {
+	return NewPager(func(ctx context.Context, cursor string) (*Page[T], error) {
+		items, err := list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &Page[T]{Items: items}, nil
+	})
+}
+
+// Next returns the next item, fetching the next page if needed. It returns
+// io.EOF after the last item. Once it has failed, it keeps returning the error.
+func (p *Pager[T]) Next(ctx context.Context) (T, error) {
+	var zero T
+	for len(p.items) == 0 {
+		if p.err != nil {
+			return zero, p.err
+		}
+		if p.fetched && p.cursor == "" {
+			p.err = io.EOF
+			return zero, p.err
+		}
+
+		page, err := p.fetch(ctx, p.cursor)
+		if err != nil {
+			p.err = err
+			return zero, err
+		}
+		p.fetched = true
+		if page == nil {
+			p.err = io.EOF
+			return zero, p.err
+		}
+		p.items = page.Items
+		p.cursor = page.NextCursor
+	}
+
+	item := p.items[0]
+	p.items = p.items[1:]
+	return item, nil
+}
+
+// All returns an iterator over the remaining items, for use with range. The
+// iteration stops after yielding the first error other than io.EOF.
+func (p *Pager[T]) All(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			item, err := p.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+			if !yield(item, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Collect returns the remaining items.
+func (p *Pager[T]) Collect(ctx context.Context) ([]T, error) {
+	var items []T
+	for item, err := range p.All(ctx) {
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
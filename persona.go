@@ -0,0 +1,133 @@
+package gigago
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Persona describes a reusable assistant personality: a system instruction,
+// few-shot examples, tone constraints and default generation parameters.
+// Personas are plain data, so they can be loaded from configuration files
+// instead of being scattered across the code as constants.
+//
+// SystemInstruction, Tone and the content of Examples are Go templates
+// (text/template) and may contain variable slots such as {{.company}}.
+// All slots listed in Variables must be provided when the persona is applied.
+type Persona struct {
+	// Name identifies the persona. It is used in error messages only.
+	Name string `json:"name"`
+
+	// SystemInstruction is the system prompt template.
+	SystemInstruction string `json:"system_instruction"`
+
+	// Examples are few-shot messages sent after the system instruction and
+	// before the conversation. Only user and assistant roles are allowed.
+	Examples []Message `json:"examples,omitempty"`
+
+	// Tone lists constraints on the style of the answers (e.g. "Be concise").
+	// They are appended to the system instruction.
+	Tone []string `json:"tone,omitempty"`
+
+	// Variables lists the names of the slots that must be filled.
+	Variables []string `json:"variables,omitempty"`
+
+	// Config holds the default generation parameters of the persona.
+	Config GenerationConfig `json:"config,omitzero"`
+}
+
+// Validate checks that the persona is well formed: it has a system instruction,
+// its templates parse, and its examples use only user and assistant roles.
+// All problems found are returned joined into a single error.
+func (p *Persona) Validate() error {
+	var errs []error
+	if strings.TrimSpace(p.SystemInstruction) == "" {
+		errs = append(errs, errors.New("system instruction is empty"))
+	}
+	for i, text := range p.templates() {
+		if _, err := template.New(fmt.Sprint(i)).Parse(text); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, m := range p.Examples {
+		if m.Role != RoleUser && m.Role != RoleAssistant {
+			errs = append(errs, fmt.Errorf("example %d has role %q, only user and assistant are allowed", i, m.Role))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("persona %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// WithPersona returns a copy of the model configured with the persona.
+// The persona's slots are filled from vars; an error is returned if the persona
+// is invalid or if a declared or referenced variable is missing.
+// The original model is left unchanged.
+func (g *GenerativeModel) WithPersona(p *Persona, vars map[string]string) (*GenerativeModel, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	for _, name := range p.Variables {
+		if _, ok := vars[name]; !ok {
+			return nil, fmt.Errorf("persona %q: missing variable %q", p.Name, name)
+		}
+	}
+
+	instruction, err := renderTemplate(p.SystemInstruction, vars)
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %w", p.Name, err)
+	}
+
+	if len(p.Tone) > 0 {
+		var sb strings.Builder
+		sb.WriteString(instruction)
+		for _, t := range p.Tone {
+			tone, err := renderTemplate(t, vars)
+			if err != nil {
+				return nil, fmt.Errorf("persona %q: %w", p.Name, err)
+			}
+			sb.WriteString("\n- ")
+			sb.WriteString(tone)
+		}
+		instruction = sb.String()
+	}
+
+	examples := make([]Message, len(p.Examples))
+	for i, m := range p.Examples {
+		content, err := renderTemplate(m.Content, vars)
+		if err != nil {
+			return nil, fmt.Errorf("persona %q: example %d: %w", p.Name, i, err)
+		}
+		examples[i] = Message{Role: m.Role, Content: content}
+	}
+
+	model := g.withSystemInstruction(instruction)
+	model.examples = examples
+	p.Config.apply(model)
+
+	return model, nil
+}
+
+func (p *Persona) templates() []string {
+	texts := make([]string, 0, 1+len(p.Tone)+len(p.Examples))
+	texts = append(texts, p.SystemInstruction)
+	texts = append(texts, p.Tone...)
+	for _, m := range p.Examples {
+		texts = append(texts, m.Content)
+	}
+	return texts
+}
+
+func renderTemplate(text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,71 @@
+package gigago
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// WithPinnedServerCert provides an Option to trust servers by the SHA-256 hash
+// of their leaf certificate instead of by the CA chain. The hash may be taken
+// of the DER encoding of the whole certificate or of its public key
+// (SubjectPublicKeyInfo); the latter survives certificate renewals that keep
+// the key.
+//
+// The pins replace the verification of the chain, so self-signed certificates
+// can be trusted, but host names are still verified. The option may be used
+// several times to accept several certificates, e.g. those of the API and
+// OAuth hosts, or the current and the next one during a rotation.
+func WithPinnedServerCert(sha256 []byte) Option {
+	return func(c *Client) {
+		c.certPins = append(c.certPins, bytes.Clone(sha256))
+	}
+}
+
+// errCertNotPinned is returned by the TLS handshake with a server whose
+// certificate matches no pin.
+var errCertNotPinned = errors.New("server certificate matches no pinned hash")
+
+// installCertPins sets up the TLS configuration of the transport for WithPinnedServerCert.
+func (c *Client) installCertPins() error {
+	if len(c.certPins) == 0 {
+		return nil
+	}
+	for _, pin := range c.certPins {
+		if len(pin) != sha256.Size {
+			return fmt.Errorf("pinned certificate hash must be %d bytes, got %d", sha256.Size, len(pin))
+		}
+	}
+
+	transport := c.transport("WithPinnedServerCert")
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	config := transport.TLSClientConfig
+	// The chain is not verified; VerifyConnection checks the pins instead.
+	config.InsecureSkipVerify = true
+	pins := c.certPins
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errCertNotPinned
+		}
+		leaf := cs.PeerCertificates[0]
+		certHash := sha256.Sum256(leaf.Raw)
+		keyHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if !bytes.Equal(pin, certHash[:]) && !bytes.Equal(pin, keyHash[:]) {
+				continue
+			}
+			// ServerName is empty for servers addressed by IP, which the pin
+			// identifies on its own.
+			if cs.ServerName == "" {
+				return nil
+			}
+			return leaf.VerifyHostname(cs.ServerName)
+		}
+		return errCertNotPinned
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// StepKind is the kind of a pipeline Step.
+type StepKind string
+
+const (
+	// StepPrompt sends Prompt to the model as a user message and stores the answer.
+	StepPrompt StepKind = "prompt"
+
+	// StepTool calls the tool named Tool with Input and stores its result.
+	StepTool StepKind = "tool"
+
+	// StepBranch runs Then if Condition holds and Else otherwise.
+	StepBranch StepKind = "branch"
+
+	// StepLoop runs Body while Condition holds, at most MaxIterations times.
+	StepLoop StepKind = "loop"
+)
+
+// Step is a step of a Pipeline.
+//
+// Prompt, Input and Condition are Go templates (text/template) executed with
+// the variables of the pipeline, e.g. "Summarize: {{.text}}". A condition holds
+// if it renders to a true boolean ("true", "1", ...); an empty result is false.
+type Step struct {
+	// Name identifies the step in traces and errors. It is also the default
+	// variable the output of prompt and tool steps is stored in.
+	Name string `json:"name"`
+
+	// Kind is the kind of the step.
+	Kind StepKind `json:"kind"`
+
+	// Prompt is the user message template of a prompt step.
+	Prompt string `json:"prompt,omitempty"`
+
+	// Tool is the name of the tool called by a tool step.
+	Tool string `json:"tool,omitempty"`
+
+	// Input is the input template of a tool step.
+	Input string `json:"input,omitempty"`
+
+	// Output is the variable the output of a prompt or tool step is stored in.
+	// Defaults to Name.
+	Output string `json:"output,omitempty"`
+
+	// Condition is the condition template of a branch or loop step.
+	Condition string `json:"condition,omitempty"`
+
+	// Then and Else are the steps of a branch step.
+	Then []Step `json:"then,omitempty"`
+	Else []Step `json:"else,omitempty"`
+
+	// Body are the steps of a loop step.
+	Body []Step `json:"body,omitempty"`
+
+	// MaxIterations limits the iterations of a loop step. It must be positive.
+	MaxIterations int `json:"max_iterations,omitempty"`
+}
+
+// Pipeline is a simple agent declared as data: a sequence of steps that prompt
+// the model, call tools, branch and loop, passing values through variables.
+// Pipelines can be loaded from configuration files and reused across services.
+type Pipeline struct {
+	// Name identifies the pipeline. It is used in error messages only.
+	Name string `json:"name"`
+
+	// Steps are run in order.
+	Steps []Step `json:"steps"`
+}
+
+// PipelineTool is a function called by tool steps with their rendered input.
+type PipelineTool func(ctx context.Context, input string) (string, error)
+
+// StepTrace records the execution of a step.
+type StepTrace struct {
+	// Step is the name of the step.
+	Step string
+
+	// Kind is the kind of the step.
+	Kind StepKind
+
+	// Iteration is the 1-based iteration of the enclosing loop, 0 outside loops.
+	Iteration int
+
+	// Input is the rendered prompt, tool input or condition.
+	Input string
+
+	// Output is the output of a prompt or tool step, or "true" or "false" for
+	// the condition of a branch or loop step.
+	Output string
+
+	// Usage is the token usage of a prompt step.
+	Usage UsageStats
+
+	// Duration is the time spent on the step, excluding nested steps.
+	Duration time.Duration
+
+	// Err is the error the step failed with, if any.
+	Err error
+}
+
+// PipelineResult is the result of a pipeline run.
+type PipelineResult struct {
+	// Vars holds the variables at the end of the run.
+	Vars map[string]string
+
+	// Output is the output of the last prompt or tool step.
+	Output string
+
+	// Trace lists the steps run, in order.
+	Trace []StepTrace
+}
+
+// Validate checks that the pipeline is well formed: every step has a name and
+// a known kind with the fields it needs, templates parse and loops are bounded.
+// All problems found are returned joined into a single error.
+func (p *Pipeline) Validate() error {
+	var errs []error
+	validateSteps(p.Steps, &errs)
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("pipeline %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+func validateSteps(steps []Step, errs *[]error) {
+	for _, s := range steps {
+		fail := func(format string, args ...any) {
+			*errs = append(*errs, fmt.Errorf("step %q: %s", s.Name, fmt.Sprintf(format, args...)))
+		}
+		if s.Name == "" {
+			fail("name is empty")
+		}
+		for _, text := range []string{s.Prompt, s.Input, s.Condition} {
+			if _, err := template.New(s.Name).Parse(text); err != nil {
+				fail("%v", err)
+			}
+		}
+
+		switch s.Kind {
+		case StepPrompt:
+			if strings.TrimSpace(s.Prompt) == "" {
+				fail("prompt is empty")
+			}
+		case StepTool:
+			if s.Tool == "" {
+				fail("tool is not set")
+			}
+		case StepBranch:
+			if s.Condition == "" {
+				fail("condition is empty")
+			}
+			validateSteps(s.Then, errs)
+			validateSteps(s.Else, errs)
+		case StepLoop:
+			if s.Condition == "" {
+				fail("condition is empty")
+			}
+			if s.MaxIterations <= 0 {
+				fail("max_iterations must be positive, got %d", s.MaxIterations)
+			}
+			validateSteps(s.Body, errs)
+		default:
+			fail("unknown kind %q", s.Kind)
+		}
+	}
+}
+
+// RunPipeline runs the pipeline with the model, starting with the given
+// variables. Tool steps call the functions of tools by name.
+//
+// The result is returned even if a step fails, with the trace of the steps run
+// so far, so failed runs can be inspected.
+func (g *GenerativeModel) RunPipeline(ctx context.Context, p *Pipeline, tools map[string]PipelineTool, vars map[string]string) (*PipelineResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &pipelineRun{model: g, tools: tools, result: &PipelineResult{Vars: make(map[string]string, len(vars))}}
+	for k, v := range vars {
+		r.result.Vars[k] = v
+	}
+	if err := r.run(ctx, p.Steps, 0); err != nil {
+		return r.result, fmt.Errorf("pipeline %q: %w", p.Name, err)
+	}
+	return r.result, nil
+}
+
+// pipelineRun holds the state of a pipeline run.
+type pipelineRun struct {
+	model  *GenerativeModel
+	tools  map[string]PipelineTool
+	result *PipelineResult
+}
+
+func (r *pipelineRun) run(ctx context.Context, steps []Step, iteration int) error {
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.step(ctx, s, iteration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *pipelineRun) step(ctx context.Context, s Step, iteration int) error {
+	switch s.Kind {
+	case StepBranch:
+		holds, err := r.condition(s, iteration)
+		if err != nil {
+			return err
+		}
+		if holds {
+			return r.run(ctx, s.Then, iteration)
+		}
+		return r.run(ctx, s.Else, iteration)
+
+	case StepLoop:
+		for i := 1; i <= s.MaxIterations; i++ {
+			holds, err := r.condition(s, i)
+			if err != nil {
+				return err
+			}
+			if !holds {
+				return nil
+			}
+			if err := r.run(ctx, s.Body, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	trace := StepTrace{Step: s.Name, Kind: s.Kind, Iteration: iteration}
+	start := time.Now()
+	output, err := r.call(ctx, s, &trace)
+	trace.Duration = time.Since(start)
+	trace.Output = output
+	trace.Err = err
+	r.result.Trace = append(r.result.Trace, trace)
+	if err != nil {
+		return fmt.Errorf("step %q: %w", s.Name, err)
+	}
+
+	name := s.Output
+	if name == "" {
+		name = s.Name
+	}
+	r.result.Vars[name] = output
+	r.result.Output = output
+	return nil
+}
+
+// call runs a prompt or tool step and returns its output.
+func (r *pipelineRun) call(ctx context.Context, s Step, trace *StepTrace) (string, error) {
+	if s.Kind == StepTool {
+		tool, ok := r.tools[s.Tool]
+		if !ok {
+			return "", fmt.Errorf("unknown tool %q", s.Tool)
+		}
+		input, err := renderTemplate(s.Input, r.result.Vars)
+		if err != nil {
+			return "", err
+		}
+		trace.Input = input
+		return tool(ctx, input)
+	}
+
+	prompt, err := renderTemplate(s.Prompt, r.result.Vars)
+	if err != nil {
+		return "", err
+	}
+	trace.Input = prompt
+	resp, err := r.model.Generate(ctx, []Message{{Role: RoleUser, Content: prompt}})
+	if err != nil {
+		return "", err
+	}
+	trace.Usage = resp.Usage
+	if len(resp.Choices) == 0 {
+		return "", errors.New("response has no choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// condition evaluates the condition of a branch or loop step and records it.
+func (r *pipelineRun) condition(s Step, iteration int) (bool, error) {
+	trace := StepTrace{Step: s.Name, Kind: s.Kind, Iteration: iteration}
+	start := time.Now()
+	rendered, err := renderTemplate(s.Condition, r.result.Vars)
+	holds := false
+	if err == nil {
+		trace.Input = rendered
+		if value := strings.TrimSpace(rendered); value != "" {
+			holds, err = strconv.ParseBool(value)
+		}
+	}
+	trace.Duration = time.Since(start)
+	trace.Output = strconv.FormatBool(holds)
+	trace.Err = err
+	r.result.Trace = append(r.result.Trace, trace)
+	if err != nil {
+		return false, fmt.Errorf("step %q: condition: %w", s.Name, err)
+	}
+	return holds, nil
+}
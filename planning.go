@@ -0,0 +1,48 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const planningInstruction = `You are a planner. Write a short numbered plan (at most 7 steps) for answering the user's last message well: what to clarify, which facts to use, how to structure the answer.
+Do not write the answer itself.`
+
+const plannedAnswerInstruction = `Follow this plan to answer. The plan is internal: never mention, quote or reveal it.
+Plan:
+%s`
+
+// GenerateWithPlanning generates an answer in two phases: first the planner
+// model (usually a cheaper one) writes a plan for answering the conversation,
+// then the model generates the final answer following that plan.
+//
+// The plan is passed to the model as part of the system instruction and is never
+// returned to the caller; only the final response is. If planner is nil, the
+// model plans itself. CallOptions apply to the final request only.
+func (g *GenerativeModel) GenerateWithPlanning(ctx context.Context, messages []Message, planner *GenerativeModel, opts ...CallOption) (*CompletionResponse, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("empty message")
+	}
+	if planner == nil {
+		planner = g
+	}
+
+	planModel := planner.withSystemInstruction(planningInstruction)
+	planModel.examples = nil
+
+	planResp, err := planModel.Generate(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("planning failed: %w", err)
+	}
+	if len(planResp.Choices) == 0 || strings.TrimSpace(planResp.Choices[0].Message.Content) == "" {
+		return nil, errors.New("planning failed: empty plan")
+	}
+	plan := strings.TrimSpace(planResp.Choices[0].Message.Content)
+
+	directive := fmt.Sprintf(plannedAnswerInstruction, plan)
+	answerModel := g.withSystemInstruction(strings.TrimSpace(g.SystemInstruction + "\n\n" + directive))
+
+	return answerModel.Generate(ctx, messages, opts...)
+}
@@ -0,0 +1,68 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Prewarm opens n connections to the API host and obtains an access token if
+// the client has none, so the first requests do not pay for the TCP, TLS and
+// OAuth handshakes. It is meant to be called at startup, e.g. before a service
+// reports itself ready.
+//
+// The connections are kept idle in the pool of the HTTP client, which keeps at
+// most MaxIdleConnsPerHost of them (16 with the default client), and may be
+// closed by the server after a while. The default client also caches TLS
+// sessions, so connections opened later resume them instead of performing a
+// full handshake. Prewarm sends no API calls and spends no tokens. It does
+// nothing if n is 0 and returns an error if n is negative.
+func (c *Client) Prewarm(ctx context.Context, n int) error {
+	if n < 0 {
+		return fmt.Errorf("prewarm: negative number of connections %d", n)
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := c.token(ctx); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(c.baseURLAI)
+	if err != nil {
+		return fmt.Errorf("invalid API URL: %w", err)
+	}
+	target := u.Scheme + "://" + u.Host + "/"
+
+	// The requests are sent concurrently so that each needs its own connection.
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.prewarmConn(ctx, target)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// prewarmConn sends a HEAD request to target. Any response will do, as it
+// leaves an established connection in the pool.
+func (c *Client) prewarmConn(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open connection: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -0,0 +1,96 @@
+package gigago
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ProfanityMasker masks banned words in text received in pieces, such as the
+// deltas of a Stream, before it is shown to end users. Words are sequences of
+// letters and digits and are compared case-insensitively; each rune of a
+// banned word is replaced with the mask rune.
+//
+// A word split across pieces is held back until it is complete, so it is
+// masked even if no single piece contains it. Only words not longer than the
+// longest banned word are held back, which keeps the delay of the output short.
+// A ProfanityMasker is not safe for concurrent use; use one per stream and choice.
+type ProfanityMasker struct {
+	words  map[string]bool
+	maxLen int
+	mask   rune
+
+	// pending is the trailing word held back until it is complete.
+	pending []rune
+	// overlong is set while the current word is longer than any banned word
+	// and is written out as it arrives.
+	overlong bool
+}
+
+// NewProfanityMasker returns a masker for the given banned words, which replaces
+// their runes with '*'.
+func NewProfanityMasker(words ...string) *ProfanityMasker {
+	m := &ProfanityMasker{words: make(map[string]bool, len(words)), mask: '*'}
+	for _, word := range words {
+		word = strings.ToLower(word)
+		m.words[word] = true
+		m.maxLen = max(m.maxLen, utf8.RuneCountInString(word))
+	}
+	return m
+}
+
+// Write masks the next piece of text and returns the part that is ready to be
+// shown. The rest is returned by later calls to Write or by Flush.
+func (m *ProfanityMasker) Write(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		if !isWordRune(r) {
+			m.flushWord(&out)
+			m.overlong = false
+			out.WriteRune(r)
+			continue
+		}
+		if m.overlong {
+			out.WriteRune(r)
+			continue
+		}
+		m.pending = append(m.pending, r)
+		if len(m.pending) > m.maxLen {
+			out.WriteString(string(m.pending))
+			m.pending = m.pending[:0]
+			m.overlong = true
+		}
+	}
+	return out.String()
+}
+
+// Flush returns the text held back at the end of the stream.
+func (m *ProfanityMasker) Flush() string {
+	var out strings.Builder
+	m.flushWord(&out)
+	m.overlong = false
+	return out.String()
+}
+
+// flushWord writes the pending word, masked if it is banned.
+func (m *ProfanityMasker) flushWord(out *strings.Builder) {
+	if len(m.pending) == 0 {
+		return
+	}
+	word := string(m.pending)
+	if m.words[strings.ToLower(word)] {
+		word = strings.Repeat(string(m.mask), len(m.pending))
+	}
+	out.WriteString(word)
+	m.pending = m.pending[:0]
+}
+
+// MaskProfanity masks the banned words in a complete text.
+func MaskProfanity(text string, words ...string) string {
+	m := NewProfanityMasker(words...)
+	return m.Write(text) + m.Flush()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,52 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenProvider obtains access tokens in place of the OAuth endpoint, e.g.
+// from a corporate gateway that issues GigaChat tokens. Implementations must
+// be safe for concurrent use.
+type TokenProvider interface {
+	// Token returns a new access token. If its ExpiresAt is zero, the token is
+	// used until the API rejects it.
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenProviderFunc is an adapter to allow the use of ordinary functions as a TokenProvider.
+type TokenProviderFunc func(ctx context.Context) (*Token, error)
+
+// Token calls f(ctx).
+func (f TokenProviderFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// WithTokenProvider provides an Option to obtain access tokens from p instead
+// of the OAuth endpoint: the client calls p whenever it needs a new token, when
+// it is created (unless WithLazyAuth is used), before the token expires and
+// after the API rejects it. The authorization key passed to NewClient may be
+// empty. Tokens for ContextWithCredentials and WithKeyBalancing are still
+// obtained from the OAuth endpoint.
+func WithTokenProvider(p TokenProvider) Option {
+	return func(c *Client) {
+		c.tokenProvider = p
+	}
+}
+
+// providerToken obtains a token from the token provider.
+func (c *Client) providerToken(ctx context.Context) (*tokenResponse, error) {
+	token, err := c.tokenProvider.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.AccessToken == "" {
+		return nil, errors.New("token provider returned no token")
+	}
+
+	var expiresAt int64
+	if !token.ExpiresAt.IsZero() {
+		expiresAt = token.ExpiresAt.UnixMilli()
+	}
+	return &tokenResponse{AccessToken: token.AccessToken, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,153 @@
+package gigago
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority is the priority of a request in the queue of WithMaxConcurrentRequests.
+// The GigaChat API has no QoS hints, so priorities are enforced by the client.
+type Priority int
+
+const (
+	// PriorityBackground is for batch jobs that may wait for other traffic.
+	PriorityBackground Priority = -1
+	// PriorityNormal is the priority of requests without a priority.
+	PriorityNormal Priority = 0
+	// PriorityInteractive is for requests a user is waiting for.
+	PriorityInteractive Priority = 1
+)
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a copy of ctx that gives the requests issued
+// with it the priority p. Higher priorities are admitted first when requests
+// queue up because of WithMaxConcurrentRequests; the priority has no effect
+// otherwise.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFromContext returns the priority stored in ctx, or PriorityNormal if none.
+func priorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// WithMaxConcurrentRequests provides an Option to limit the number of API calls
+// in flight to n, retries included; streams count until they are closed. Calls
+// beyond the limit wait in a queue ordered by priority (see ContextWithPriority)
+// and then by arrival, so interactive traffic overtakes batch jobs. OAuth
+// requests are not limited. n must be positive.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(c *Client) {
+		if n < 1 {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithMaxConcurrentRequests: n must be positive, got %d", n))
+			return
+		}
+		c.queue = &requestQueue{limit: n}
+	}
+}
+
+// requestQueue admits at most limit calls at a time, by priority.
+type requestQueue struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting waiterHeap
+	seq     uint64
+}
+
+type queueWaiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// acquire waits until a call with the priority of ctx may proceed and returns
+// the function to call when it is done. It returns an error if ctx is done first.
+func (q *requestQueue) acquire(ctx context.Context) (release func(), err error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.active < q.limit && len(q.waiting) == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	q.seq++
+	w := &queueWaiter{priority: priorityFromContext(ctx), seq: q.seq, ready: make(chan struct{})}
+	heap.Push(&q.waiting, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.index < 0 {
+		// The slot was handed over meanwhile; pass it on.
+		q.releaseLocked()
+	} else {
+		heap.Remove(&q.waiting, w.index)
+	}
+	return nil, ctx.Err()
+}
+
+func (q *requestQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+// releaseLocked hands the slot of a finished call over to the first waiting
+// call, if any.
+func (q *requestQueue) releaseLocked() {
+	if len(q.waiting) > 0 {
+		w := heap.Pop(&q.waiting).(*queueWaiter)
+		close(w.ready)
+		return
+	}
+	q.active--
+}
+
+// waiterHeap orders waiting calls by descending priority, then by arrival.
+type waiterHeap []*queueWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*queueWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
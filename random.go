@@ -0,0 +1,36 @@
+package gigago
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// lockedRand is a *rand.Rand safe for concurrent use.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+// WithRandSource provides an Option to set the source of randomness used by the
+// client's randomized behaviors (retry jitter, failure injection), so that
+// simulations and tests of resilience behavior are reproducible.
+// Defaults to the global source of math/rand/v2.
+func WithRandSource(src rand.Source) Option {
+	return func(c *Client) {
+		c.rand = &lockedRand{r: rand.New(src)}
+	}
+}
+
+// randFloat64 returns a pseudo-random number in [0, 1).
+func (c *Client) randFloat64() float64 {
+	if c.rand == nil {
+		return rand.Float64()
+	}
+	return c.rand.float64()
+}
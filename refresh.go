@@ -2,7 +2,6 @@ package gigago
 
 import (
 	"context"
-	"log"
 	"time"
 )
 
@@ -11,20 +10,28 @@ const (
 	tokenRefreshBuffer = 15 * time.Minute
 	// tokenRefreshInterval is how often to check if token needs refresh
 	tokenRefreshInterval = 1 * time.Minute
-	// refreshTimeout is the timeout for token refresh requests
-	refreshTimeout = 30 * time.Second
 )
 
 // isValid checks if the token is still fresh enough for use.
 // It returns true if the token's expiration time is more than 15 minutes in the future.
 // This 15-minute buffer provides a safe window to prevent using an expired token
 // for requests that might take time to complete.
-// The expire_at timestamp is expected to be in Unix milliseconds.
+// The expire_at timestamp is expected to be in Unix milliseconds, but timestamps
+// in seconds are detected and handled as well.
 func (c *Client) isValid(expire_at int64, now time.Time) bool {
-	nowMs := now.UnixMilli()
-	remaining := expire_at - nowMs
-	fifteenMinutesMs := int64(tokenRefreshBuffer / time.Millisecond)
-	return remaining > fifteenMinutesMs
+	remaining := unixTime(expire_at).Sub(now)
+	return remaining > tokenRefreshBuffer
+}
+
+// TokenExpiry returns the expiration time of the client's current access token,
+// or the zero Time if the client has no token.
+func (c *Client) TokenExpiry() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.accessToken == nil {
+		return time.Time{}
+	}
+	return unixTime(c.accessToken.ExpiresAt)
 }
 
 // tokenRefresher runs in a background goroutine to proactively refresh the access token.
@@ -46,17 +53,15 @@ func (c *Client) tokenRefresher(ctx context.Context) {
 				return
 			}
 
+			// A missing token (lazy authentication) is obtained by the first request.
 			c.mu.RLock()
-			shouldRefresh := !c.isValid(c.accessToken.ExpiresAt, time.Now())
+			token := c.accessToken
 			c.mu.RUnlock()
 
-			if shouldRefresh {
-				reqCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
-				err := c.refreshToken(reqCtx)
-				cancel()
-
-				if err != nil {
-					log.Printf("gigago: failed to refresh token in background: %v", err)
+			// Tokens without an expiration time are replaced once rejected.
+			if token != nil && token.ExpiresAt != 0 && !c.isValid(token.ExpiresAt, time.Now()) {
+				if err := c.refreshToken(ctx, token.AccessToken); err != nil {
+					c.logf("failed to refresh token in background: %v", err)
 				}
 			}
 
@@ -66,45 +71,82 @@ func (c *Client) tokenRefresher(ctx context.Context) {
 	}
 }
 
-func (c *Client) refreshToken(ctx context.Context) error {
+// refreshCall is a token refresh shared by the callers that need it at the same time.
+type refreshCall struct {
+	done chan struct{}
+	err  error
+	// token is the token obtained by a refresh for credentials.
+	token *tokenResponse
+}
+
+// refreshToken obtains a new access token, replacing stale, the token the caller
+// found expiring or had rejected ("" if there was none). Concurrent calls share
+// a single OAuth request, and a call made after the token was already replaced
+// returns at once, so a burst of 401s refreshes the token only once.
+//
+// The OAuth request is not canceled with ctx, as other callers may be waiting
+// for it; ctx only bounds how long this call waits. It is canceled by Close,
+// and otherwise bounded by the request timeout of the client only, which
+// leaves room for the backoff of rate limited token requests.
+func (c *Client) refreshToken(ctx context.Context, stale string) error {
 	c.refreshMu.Lock()
-	if c.refreshing {
-		ch := make(chan error, 1)
-		if c.refreshWaiters == nil {
-			c.refreshWaiters = make([]chan error, 0, 4)
+	call := c.refresh
+	if call == nil {
+		c.mu.RLock()
+		current := c.accessToken
+		c.mu.RUnlock()
+		if current != nil && current.AccessToken != stale {
+			c.refreshMu.Unlock()
+			return nil
 		}
-		c.refreshWaiters = append(c.refreshWaiters, ch)
-		c.refreshMu.Unlock()
-		return <-ch
+
+		call = &refreshCall{done: make(chan struct{})}
+		c.refresh = call
+		c.wg.Add(1)
+		go c.runRefresh(context.WithoutCancel(ctx), call, stale)
 	}
-	c.refreshing = true
 	c.refreshMu.Unlock()
 
-	// Select the function to get the token
-	var (
-		token *tokenResponse
-		err   error
-	)
-	if c.oauthCreateFunc != nil {
-		token, err = c.oauthCreateFunc(ctx)
-	} else {
-		token, err = c.oauthCreate(ctx)
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
+
+// runRefresh obtains the token of call to replace stale and stores it.
+func (c *Client) runRefresh(ctx context.Context, call *refreshCall, stale string) {
+	defer c.wg.Done()
+	ctx, cancel := c.detachedContext(ctx)
+	defer cancel()
+
+	token, err := c.obtainToken(ctx, stale)
 
 	c.mu.Lock()
 	if err == nil {
 		c.accessToken = token
+		c.stats.tokenRefreshes.Add(1)
 	}
 	c.mu.Unlock()
 
 	c.refreshMu.Lock()
-	for _, waiter := range c.refreshWaiters {
-		waiter <- err
-		close(waiter)
-	}
-	c.refreshWaiters = nil
-	c.refreshing = false
+	call.err = err
+	c.refresh = nil
+	close(call.done)
 	c.refreshMu.Unlock()
+}
 
-	return err
+// detachedContext returns a context for work shared by several callers, such
+// as a token refresh: it keeps the values of ctx but is canceled by Close only.
+func (c *Client) detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	if c.ctx == nil {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(c.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
 }
@@ -0,0 +1,74 @@
+package gigago
+
+import "strings"
+
+// refusalPrefixRunes is the length of the beginning of an answer searched for
+// refusal phrases, in runes.
+const refusalPrefixRunes = 200
+
+// refusalPhrases are typical beginnings of refusal-style answers, lowercased.
+var refusalPhrases = []string{
+	// GigaChat's canned answers for filtered topics
+	"не люблю менять тему разговора",
+	"что-то в вашем вопросе меня смущает",
+	"как у нейросетевой языковой модели у меня не может быть настроения",
+	// Russian
+	"я не могу помочь",
+	"я не могу ответить",
+	"я не могу выполнить",
+	"к сожалению, я не могу",
+	"извините, но я не могу",
+	"извините, я не могу",
+	"я не буду",
+	// English
+	"i can't help with",
+	"i cannot help with",
+	"i can't assist with",
+	"i cannot assist with",
+	"i'm sorry, but i can't",
+	"i'm sorry, but i cannot",
+	"i am unable to",
+	"i won't be able to",
+}
+
+// IsRefusal is the default refusal detector. It reports whether the answer was
+// blocked by the content filter (finish reason "blacklist") or starts like a
+// refusal ("I can't help with that", "Не люблю менять тему разговора...").
+// It is a heuristic meant for analytics, not for access control.
+func IsRefusal(content string, finishReason FinishReason) bool {
+	if finishReason == FinishReasonBlacklist {
+		return true
+	}
+
+	text := strings.ToLower(strings.TrimSpace(content))
+	text = strings.ReplaceAll(text, "’", "'")
+	if runes := []rune(text); len(runes) > refusalPrefixRunes {
+		text = string(runes[:refusalPrefixRunes])
+	}
+	for _, phrase := range refusalPhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRefusalDetector provides an Option to replace the heuristic used to set
+// CompletionResponse.Refusal (IsRefusal by default).
+func WithRefusalDetector(detect func(content string, finishReason FinishReason) bool) Option {
+	return func(c *Client) {
+		c.refusalDetector = detect
+	}
+}
+
+// isRefusal reports whether the first choice of the response is a refusal.
+func (c *Client) isRefusal(resp *CompletionResponse) bool {
+	if len(resp.Choices) == 0 {
+		return false
+	}
+	detect := c.refusalDetector
+	if detect == nil {
+		detect = IsRefusal
+	}
+	return detect(resp.Choices[0].Message.Content, resp.Choices[0].FinishReason)
+}
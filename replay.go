@@ -0,0 +1,151 @@
+package gigago
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ReadAuditLog reads the entries of an audit log written by a client configured
+// with WithAuditLog. Empty lines are skipped.
+func ReadAuditLog(r io.Reader) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReplayOptions configures Client.Replay.
+type ReplayOptions struct {
+	// Filter selects the entries to replay. All chat completion entries are
+	// replayed if nil.
+	Filter func(AuditEntry) bool
+
+	// Model, if set, replaces the model of the recorded requests, e.g. to
+	// compare the answers of a new model version.
+	Model string
+
+	// Endpoint, if set, is the chat completions URL the requests are sent to
+	// instead of the client's one.
+	Endpoint string
+}
+
+// ReplayResult is the outcome of replaying a single audit log entry.
+type ReplayResult struct {
+	// Entry is the replayed entry.
+	Entry AuditEntry
+
+	// Original is the recorded response, nil if the recorded call failed or its
+	// response could not be decoded.
+	Original *CompletionResponse
+
+	// Replayed is the new response, nil if Err is set.
+	Replayed *CompletionResponse
+
+	// Changed reports whether the content of the answers differs.
+	Changed bool
+
+	// Err is the error of the replayed request.
+	Err error
+}
+
+// Replay sends the chat completion requests recorded in the audit log entries
+// again and compares the answers with the recorded ones, which helps to find
+// prompt regressions after model version bumps. Entries of other requests
+// (e.g. file uploads) are skipped. The requests are replayed sequentially, in order.
+//
+// Replayed requests contain the recorded bodies as is, so data masked with
+// WithRedaction is sent masked.
+func (c *Client) Replay(ctx context.Context, entries []AuditEntry, opts ReplayOptions) []ReplayResult {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = c.baseURLAI
+	}
+
+	var results []ReplayResult
+	for _, entry := range entries {
+		if !isCompletionRequest(entry.Request) {
+			continue
+		}
+		if opts.Filter != nil && !opts.Filter(entry) {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		result := ReplayResult{Entry: entry}
+		if entry.Error == "" {
+			var original CompletionResponse
+			if json.Unmarshal(entry.Response, &original) == nil {
+				result.Original = &original
+			}
+		}
+
+		body, err := replayBody(entry.Request, opts.Model)
+		if err == nil {
+			var replayed CompletionResponse
+			err = c.doJSON(ctx, http.MethodPost, endpoint, body, &replayed)
+			if err == nil {
+				result.Replayed = &replayed
+			}
+		}
+		result.Err = err
+		result.Changed = err != nil || result.Original == nil || answerContent(result.Original) != answerContent(result.Replayed)
+
+		results = append(results, result)
+	}
+	return results
+}
+
+// isCompletionRequest reports whether request is the body of a chat completion request.
+func isCompletionRequest(request json.RawMessage) bool {
+	var body struct {
+		Messages []Message `json:"messages"`
+	}
+	return json.Unmarshal(request, &body) == nil && len(body.Messages) > 0
+}
+
+// replayBody returns the recorded request body with the model replaced, if set.
+// Streamed requests are replayed without streaming; their recorded response is
+// the completion assembled from the chunks.
+func replayBody(request json.RawMessage, model string) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(request, &body); err != nil {
+		return nil, fmt.Errorf("invalid recorded request: %w", err)
+	}
+	delete(body, "stream")
+	if model != "" {
+		name, err := json.Marshal(model)
+		if err != nil {
+			return nil, err
+		}
+		body["model"] = name
+	}
+	return json.Marshal(body)
+}
+
+// answerContent returns the content of the first choice of resp.
+func answerContent(resp *CompletionResponse) string {
+	if len(resp.Choices) == 0 {
+		return ""
+	}
+	return resp.Choices[0].Message.Content
+}
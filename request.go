@@ -0,0 +1,238 @@
+package gigago
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// callResult describes the outcome of the attempts made by a single call.
+type callResult struct {
+	// attempt is the number of the last attempt.
+	attempt int
+	// errorClass is the error class used for statistics, empty on success.
+	errorClass string
+	// status is the status code of the last response, 0 if none was received.
+	status int
+	// body is the body of the last response.
+	body []byte
+	// header is the header of the last response.
+	header http.Header
+	// reauthed is set once the access token was refreshed after a 401
+	// response, which is done at most once per call.
+	reauthed bool
+	// streamed is set for calls whose response was streamed.
+	streamed bool
+}
+
+// contentTypeJSON is the content type of JSON request bodies.
+const contentTypeJSON = "application/json"
+
+// doJSON sends an authorized request with the JSON body to endpoint and decodes
+// a successful JSON response into out. See do for details.
+func (c *Client) doJSON(ctx context.Context, method, endpoint string, body []byte, out any) error {
+	return c.do(ctx, method, endpoint, contentTypeJSON, body, out)
+}
+
+// do sends an authorized request with the body of the given content type to
+// endpoint and decodes a successful JSON response into out. If out is an
+// io.Writer, the raw response is written to it instead, once it is received
+// completely.
+//
+// If the request fails with an authentication error (HTTP 401), the access token
+// is refreshed and the request is retried once. Other failures are retried
+// according to the policy set by WithRetry. All failures are returned as
+// *RequestError and recorded in the client statistics and the audit log.
+func (c *Client) do(ctx context.Context, method, endpoint, contentType string, body []byte, out any) error {
+	start := time.Now()
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	var (
+		res      callResult
+		err      error
+		attempts int
+		key      *balancedKey
+	)
+	release, err := c.queue.acquire(ctx)
+	if err != nil {
+		res.errorClass = ErrorClassLimiter
+		err = fmt.Errorf("request queue: %w", err)
+	} else {
+		defer release()
+		ctx, key = c.balancedContext(ctx)
+		for call := 1; ; call++ {
+			res, err = c.doAttempts(ctx, method, endpoint, contentType, body, out, res.reauthed)
+			attempts += res.attempt
+			if err == nil || !c.waitRetry(ctx, call+1, res) {
+				break
+			}
+		}
+	}
+	res.attempt = attempts
+	elapsed := time.Since(start)
+
+	if key != nil {
+		var tokens int
+		if resp, ok := out.(*CompletionResponse); ok && err == nil {
+			tokens = resp.Usage.TotalTokens
+		}
+		c.balancer.report(key, time.Now(), res.status, res.header, tokens)
+	}
+
+	c.stats.recordRequest(elapsed, res.errorClass)
+
+	c.audit(start, method, endpoint, contentType, elapsed, body, res, err)
+
+	if err != nil {
+		reqErr := newRequestError(method, endpoint, res.attempt, start, err)
+		reqErr.class = res.errorClass
+		reqErr.StatusCode = res.status
+		return reqErr
+	}
+	return nil
+}
+
+// doAttempts performs the attempts of do.
+func (c *Client) doAttempts(ctx context.Context, method, endpoint, contentType string, body []byte, out any, reauthed bool) (callResult, error) {
+	res := callResult{reauthed: reauthed}
+	accept := contentTypeJSON
+	w, raw := out.(io.Writer)
+	if raw {
+		accept = "*/*"
+	}
+	resp, err := c.send(ctx, method, endpoint, contentType, accept, body, &res)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+
+	res.status = resp.StatusCode
+	res.header = resp.Header
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		res.errorClass = ErrorClassTransport
+		return res, fmt.Errorf("failed to read response: %w", err)
+	}
+	res.body = respBody
+
+	if resp.StatusCode == http.StatusOK && raw {
+		// Raw content, e.g. a downloaded file, is not logged.
+		res.body = []byte(fmt.Sprintf(`"<%s body, %d bytes>"`, resp.Header.Get("Content-Type"), len(respBody)))
+		if _, err := w.Write(respBody); err != nil {
+			res.errorClass = ErrorClassDecode
+			return res, fmt.Errorf("failed to write response: %w", err)
+		}
+		return res, nil
+	}
+	if resp.StatusCode == http.StatusOK {
+		dec := json.NewDecoder(bytes.NewReader(respBody))
+		if c.enabled(FeatureStrictDecoding) {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(out); err != nil {
+			res.errorClass = ErrorClassDecode
+			return res, err
+		}
+		if resp, ok := out.(*CompletionResponse); ok && c.responseValidation {
+			if err := validateCompletion(resp, respBody); err != nil {
+				res.errorClass = ErrorClassDecode
+				return res, err
+			}
+		}
+		return res, nil
+	}
+
+	res.errorClass = statusErrorClass(resp.StatusCode)
+	return res, fmt.Errorf("unexpected %w", newAPIError(resp, respBody))
+}
+
+// withRequestTimeout returns ctx limited by the request timeout of the client.
+func (c *Client) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// send sends an authorized request, refreshing the access token and retrying
+// once if it is rejected (HTTP 401) and the token was not refreshed for the
+// call yet, and returns the last response. The caller
+// must close its body. res is updated with the number of attempts and the
+// error class of a failure.
+func (c *Client) send(ctx context.Context, method, endpoint, contentType, accept string, body []byte, res *callResult) (*http.Response, error) {
+	for {
+		res.attempt++
+
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				res.errorClass = ErrorClassLimiter
+				return nil, fmt.Errorf("rate limiter: %w", err)
+			}
+		}
+
+		token, err := c.token(ctx)
+		if err != nil {
+			res.errorClass = ErrorClassAuth
+			return nil, fmt.Errorf("failed to get access token: %w", err)
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			res.errorClass = ErrorClassTransport
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+			if progress, ok := ctx.Value(uploadProgressKey{}).(func(sent, total int64)); ok {
+				req.Body = io.NopCloser(&progressReader{r: req.Body, total: req.ContentLength, progress: progress})
+			}
+		}
+		req.Header.Set("Accept", accept)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.setHeaders(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			res.errorClass = ErrorClassTransport
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		c.captureHeaders(resp)
+
+		// A rejected client certificate cannot be refreshed.
+		if resp.StatusCode != http.StatusUnauthorized || res.reauthed || token == "" {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		res.reauthed = true
+
+		if err := c.reauth(ctx, token); err != nil {
+			res.errorClass = ErrorClassAuth
+			return nil, fmt.Errorf("failed to refresh token after 401: %w", err)
+		}
+	}
+}
+
+// statusErrorClass returns the error class of a response with an error status.
+func statusErrorClass(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrorClassAuth
+	case status >= 500:
+		return ErrorClassServer
+	}
+	return ErrorClassClient
+}
@@ -0,0 +1,90 @@
+package gigago
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay caps the delay between two attempts of a call retried by WithRetry.
+const maxRetryDelay = 30 * time.Second
+
+// retryPolicy configures the retries of failed calls.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// WithRetry provides an Option to retry API calls that fail with a 429 or 5xx
+// response, up to maxAttempts attempts in total. The delay before each retry
+// grows exponentially from baseDelay, with random jitter, unless the response
+// sets the Retry-After header. Retries stop early if the delay would exceed the
+// deadline of the request (see WithRequestTimeout).
+//
+// Streams (see GenerateStream) are not retried.
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		c.retry = &retryPolicy{maxAttempts: maxAttempts, baseDelay: baseDelay}
+	}
+}
+
+// retryableStatus reports whether a call that failed with the status may
+// succeed if it is retried.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500 && status != http.StatusNotImplemented
+}
+
+// waitRetry waits before attempt number attempt (2 for the first retry) of a
+// call whose previous attempt got res. It reports false, without waiting, if
+// the call should not be retried.
+func (c *Client) waitRetry(ctx context.Context, attempt int, res callResult) bool {
+	p := c.retry
+	if p == nil || attempt > p.maxAttempts || !retryableStatus(res.status) {
+		return false
+	}
+
+	delay := c.backoff(attempt-1, p.baseDelay, maxRetryDelay, retryAfterDelay(res.header, time.Now()))
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns the jittered delay before retry number retry (starting at 1),
+// so that clients failing together do not retry together. The delay grows
+// exponentially from base up to maxDelay, unless the server asked to wait for
+// retryAfter.
+func (c *Client) backoff(retry int, base, maxDelay, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter + time.Duration(c.randFloat64()*float64(retryAfter)/2)
+	}
+	delay := maxDelay
+	if retry < 32 {
+		delay = min(base<<(retry-1), maxDelay)
+	}
+	return delay/2 + time.Duration(c.randFloat64()*float64(delay)/2)
+}
+
+// retryAfterDelay returns the delay requested by the Retry-After header, given in
+// seconds or as a date, or 0 if there is none.
+func retryAfterDelay(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
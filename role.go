@@ -1,5 +1,7 @@
 package gigago
 
+import "fmt"
+
 // Role defines the author of a message in a chat conversation.
 type Role string
 
@@ -13,8 +15,21 @@ const (
 	// RoleSystem provides context or instructions for the model.
 	// It typically appears once at the beginning of a conversation.
 	RoleSystem Role = "system"
+
+	// RoleFunction carries the result of a function call requested by the model.
+	// Its content is the JSON-encoded result of the function.
+	RoleFunction Role = "function"
 )
 
+// IsKnown reports whether r is one of the roles defined by this package.
+func (r Role) IsKnown() bool {
+	switch r {
+	case RoleUser, RoleAssistant, RoleSystem, RoleFunction:
+		return true
+	}
+	return false
+}
+
 // Message represents a single message in a chat conversation.
 type Message struct {
 	// Role is the author of the message. See the Role type for possible values.
@@ -22,4 +37,48 @@ type Message struct {
 
 	// Content is the textual content of the message.
 	Content string `json:"content"`
+
+	// Name is the name of the function whose result a RoleFunction message carries.
+	Name string `json:"name,omitempty"`
+
+	// FunctionCall is the function call requested by the model in an assistant
+	// message, sent back as part of the conversation history.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// FunctionsStateID identifies the function call the message belongs to. It is
+	// copied from the ResponseMessage that requested the call.
+	FunctionsStateID string `json:"functions_state_id,omitempty"`
+
+	// Attachments are the IDs of files (see Client.UploadFile) attached to a
+	// user message, e.g. images for the model to describe. The API accepts at
+	// most one image per message.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// WithCustomRoles provides an Option to allow message roles that are not defined
+// by this package (e.g. "search_result"). By default, Generate rejects messages
+// with unknown roles instead of letting typos reach the API as confusing 400 errors.
+func WithCustomRoles(roles ...Role) Option {
+	return func(c *Client) {
+		if c.customRoles == nil {
+			c.customRoles = make(map[Role]struct{}, len(roles))
+		}
+		for _, r := range roles {
+			c.customRoles[r] = struct{}{}
+		}
+	}
+}
+
+// validateRoles checks that every message has a known or explicitly allowed role.
+func (c *Client) validateRoles(messages []Message) error {
+	for i, m := range messages {
+		if m.Role.IsKnown() {
+			continue
+		}
+		if _, ok := c.customRoles[m.Role]; ok {
+			continue
+		}
+		return fmt.Errorf("message %d: unknown role %q (use WithCustomRoles to allow it)", i, m.Role)
+	}
+	return nil
 }
@@ -0,0 +1,66 @@
+package gigago
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// WithRootCAs provides an Option to verify the certificates of the servers
+// against pool instead of the system trust store. The GigaChat endpoints use
+// certificates issued by the Russian Ministry of Digital Development (NUC
+// Mintsifry) root CA, which standard trust stores lack; trusting it is the
+// safe alternative to WithCustomInsecureSkipVerify. The client keeps a copy
+// of pool, so WithRootCAFile adds to the copy and later changes to pool have
+// no effect.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		if pool == nil {
+			c.optionErrs = append(c.optionErrs, errors.New("WithRootCAs: pool is nil"))
+			return
+		}
+		c.rootCAs = pool.Clone()
+	}
+}
+
+// WithRootCAFile provides an Option to trust the CA certificates in the file
+// at path, PEM or DER encoded, e.g. russian_trusted_root_ca.cer published by
+// the Ministry of Digital Development, in addition to the system trust store.
+// The option may be used several times to trust several files.
+func WithRootCAFile(path string) Option {
+	return func(c *Client) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithRootCAFile: %w", err))
+			return
+		}
+		if c.rootCAs == nil {
+			if c.rootCAs, err = x509.SystemCertPool(); err != nil {
+				c.rootCAs = x509.NewCertPool()
+			}
+		}
+		if c.rootCAs.AppendCertsFromPEM(data) {
+			return
+		}
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithRootCAFile: no certificates found in %s", path))
+			return
+		}
+		c.rootCAs.AddCert(cert)
+	}
+}
+
+// installRootCAs sets up the TLS configuration of the transport for WithRootCAs and WithRootCAFile.
+func (c *Client) installRootCAs() {
+	if c.rootCAs == nil {
+		return
+	}
+	transport := c.transport("WithRootCAs")
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = c.rootCAs
+}
@@ -0,0 +1,16 @@
+package gigago
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newRqUID returns a random (version 4) UUID used as the RqUID request header.
+// It is implemented here to keep the module free of third-party dependencies.
+func newRqUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
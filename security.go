@@ -0,0 +1,62 @@
+package gigago
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WithStrictSecurity provides an Option that makes NewClient refuse insecure
+// configurations instead of only logging them:
+//   - TLS certificate verification disabled by WithCustomInsecureSkipVerify;
+//   - no explicit trust configuration: the root CAs must be set with
+//     WithRootCAs or WithRootCAFile, or servers pinned with WithPinnedServerCert,
+//     rather than relying on the system certificate pool alone;
+//   - a transport whose TLS configuration cannot be inspected;
+//   - endpoints using plain HTTP;
+//   - tokens cached in plaintext by a FileTokenStore.
+func WithStrictSecurity() Option {
+	return func(c *Client) {
+		c.strictSecurity = true
+	}
+}
+
+// checkSecurity logs a warning if certificate verification is disabled and,
+// with WithStrictSecurity, returns the insecure settings of the client.
+func (c *Client) checkSecurity() error {
+	var problems []error
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	switch {
+	case c.httpClient.Transport == nil:
+		problems = append(problems, errors.New("no root CAs are configured"))
+	case !ok:
+		problems = append(problems, fmt.Errorf("the TLS configuration of a %T transport cannot be verified", c.httpClient.Transport))
+	default:
+		config := transport.TLSClientConfig
+		pinned := len(c.certPins) > 0
+		if config != nil && config.InsecureSkipVerify && !pinned {
+			c.logf("TLS certificate verification is disabled; connections are open to man-in-the-middle attacks")
+			problems = append(problems, errors.New("TLS certificate verification is disabled"))
+		}
+		if (config == nil || config.RootCAs == nil) && !pinned {
+			problems = append(problems, errors.New("no root CAs are configured"))
+		}
+	}
+	for _, endpoint := range []string{c.baseURLAI, c.baseURLOauth} {
+		if !strings.HasPrefix(endpoint, "https://") {
+			problems = append(problems, fmt.Errorf("endpoint %s does not use HTTPS", endpoint))
+		}
+	}
+	if _, ok := c.tokenStore.(*FileTokenStore); ok {
+		problems = append(problems, errors.New("FileTokenStore keeps the access token in plaintext"))
+	}
+
+	if !c.strictSecurity {
+		return nil
+	}
+	if err := errors.Join(problems...); err != nil {
+		return fmt.Errorf("strict security: %w", err)
+	}
+	return nil
+}
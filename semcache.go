@@ -0,0 +1,135 @@
+package gigago
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// defaultSemanticCacheThreshold is the default value of SemanticCache.Threshold.
+const defaultSemanticCacheThreshold = 0.95
+
+// semanticCacheCandidates is the number of nearest records checked for a hit,
+// as the nearest ones may have been answered by other models.
+const semanticCacheCandidates = 5
+
+// SemanticCache configures the cache of WithSemanticCache.
+type SemanticCache struct {
+	// Embedder computes the embeddings of the prompts, e.g. Client.Embedder.
+	Embedder Embedder
+
+	// Store keeps the prompts with their answers. Defaults to an
+	// InMemoryVectorStore; a shared store lets several processes share the cache.
+	Store VectorStore
+
+	// Threshold is the minimum cosine similarity of a cached prompt to the
+	// prompt of a request for its answer to be returned. Defaults to 0.95.
+	Threshold float64
+}
+
+// WithSemanticCache provides an Option to answer requests from a cache of
+// earlier answers to similar prompts, which saves tokens on FAQ-style traffic.
+// The prompt of each Generate call (the system instruction and the messages)
+// is embedded and looked up in the store of the cache; if a prompt sent to the
+// same model is at least Threshold similar, its answer is returned with
+// Cached set. Otherwise the request is sent and its answer stored.
+//
+// Similar is not identical: the cached answer may not fit details of the
+// prompt, so the threshold should be high and the cache reserved for models
+// whose answers do not depend on the user. Streams are not cached. If the
+// prompt cannot be embedded, the request is sent as if there was no cache.
+func WithSemanticCache(cache SemanticCache) Option {
+	return func(c *Client) {
+		if cache.Embedder == nil {
+			c.optionErrs = append(c.optionErrs, errors.New("WithSemanticCache: embedder is nil"))
+			return
+		}
+		if cache.Store == nil {
+			cache.Store = NewInMemoryVectorStore()
+		}
+		if cache.Threshold <= 0 {
+			cache.Threshold = defaultSemanticCacheThreshold
+		}
+		c.semanticCache = &cache
+	}
+}
+
+// semanticKey is the prompt of a request as looked up in the semantic cache.
+type semanticKey struct {
+	model  string
+	text   string
+	vector []float32
+}
+
+// semanticLookup returns the cached answer to a prompt similar to the one of
+// p, if any, and the key to store the answer under otherwise. The key is nil
+// if the cache is disabled or the prompt could not be embedded.
+func (c *Client) semanticLookup(ctx context.Context, p *payload) (*CompletionResponse, *semanticKey) {
+	cache := c.semanticCache
+	if cache == nil {
+		return nil, nil
+	}
+
+	var sb strings.Builder
+	for _, m := range p.Messages {
+		sb.WriteString(string(m.Role))
+		sb.WriteString(": ")
+		sb.WriteString(m.Content)
+		sb.WriteByte('\n')
+	}
+	key := &semanticKey{model: p.Model, text: sb.String()}
+
+	vectors, err := cache.Embedder.Embed(ctx, []string{key.text})
+	if err != nil || len(vectors) != 1 {
+		c.logf("semantic cache: failed to embed the prompt: %v", err)
+		return nil, nil
+	}
+	key.vector = vectors[0]
+
+	found, err := cache.Store.Query(ctx, key.vector, semanticCacheCandidates)
+	if err != nil {
+		c.logf("semantic cache: lookup failed: %v", err)
+		return nil, key
+	}
+	for _, r := range found {
+		if r.Score < cache.Threshold {
+			break
+		}
+		if r.Metadata["model"] != key.model {
+			continue
+		}
+		var resp CompletionResponse
+		if err := json.Unmarshal([]byte(r.Metadata["response"]), &resp); err != nil {
+			continue
+		}
+		resp.Cached = true
+		return &resp, key
+	}
+	return nil, key
+}
+
+// semanticStore stores the answer to the prompt of key in the semantic cache.
+func (c *Client) semanticStore(ctx context.Context, key *semanticKey, resp *CompletionResponse) {
+	if key == nil {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	id := sha256.Sum256([]byte(key.model + "\x00" + key.text))
+	record := VectorRecord{
+		MemoryRecord: MemoryRecord{
+			ID:       hex.EncodeToString(id[:]),
+			Text:     key.text,
+			Metadata: map[string]string{"model": key.model, "response": string(data)},
+		},
+		Vector: key.vector,
+	}
+	if err := c.semanticCache.Store.Upsert(ctx, []VectorRecord{record}); err != nil {
+		c.logf("semantic cache: failed to store the answer: %v", err)
+	}
+}
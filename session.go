@@ -0,0 +1,84 @@
+package gigago
+
+import (
+	"net/http"
+	"sync"
+)
+
+// WithCookieJar provides an Option to store and send cookies set by the servers,
+// which some corporate gateways in front of GigaChat use for session affinity.
+// The jar is set on the client's http.Client, so with WithCustomClient it must
+// be passed after it. Use net/http/cookiejar for a standard implementation.
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(c *Client) {
+		if c.httpClient == nil {
+			c.httpClient = &http.Client{}
+		}
+		c.httpClient.Jar = jar
+		c.httpClientOptions = append(c.httpClientOptions, "WithCookieJar")
+	}
+}
+
+// WithHeader provides an Option to send an additional header with every request,
+// including token requests. It may be used several times. The headers set by the
+// client itself (e.g. Authorization) cannot be overridden.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.headers == nil {
+			c.headers = make(http.Header)
+		}
+		c.headers.Add(key, value)
+	}
+}
+
+// WithStickyHeader provides an Option to route requests to the same backend
+// behind balancers that use a header for affinity: the last non-empty value of
+// the named response header is sent back with every following request.
+func WithStickyHeader(name string) Option {
+	return func(c *Client) {
+		c.sticky = &stickyHeader{name: http.CanonicalHeaderKey(name)}
+	}
+}
+
+// stickyHeader remembers the value of a routing header.
+type stickyHeader struct {
+	name  string
+	mu    sync.Mutex
+	value string
+}
+
+// setHeaders adds the headers set with ContextWithHeader and configured by
+// WithHeader and WithStickyHeader to req.
+func (c *Client) setHeaders(req *http.Request) {
+	for key, values := range headersFromContext(req.Context()) {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = values
+		}
+	}
+	for key, values := range c.headers {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = values
+		}
+	}
+	if s := c.sticky; s != nil {
+		s.mu.Lock()
+		value := s.value
+		s.mu.Unlock()
+		if value != "" {
+			req.Header.Set(s.name, value)
+		}
+	}
+}
+
+// captureHeaders remembers the sticky header of resp, if any.
+func (c *Client) captureHeaders(resp *http.Response) {
+	s := c.sticky
+	if s == nil {
+		return
+	}
+	if value := resp.Header.Get(s.name); value != "" {
+		s.mu.Lock()
+		s.value = value
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,127 @@
+package gigago
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSSELineSize is the maximum length of a line of an event stream.
+const maxSSELineSize = 16 << 20
+
+// sseEvent is a single event of a server-sent events stream.
+type sseEvent struct {
+	// ID is the last event ID set in the stream so far.
+	ID string
+	// Event is the event type, "message" if not set.
+	Event string
+	// Data is the event data; the lines of multi-line data are joined with "\n".
+	Data string
+	// Retry is the reconnection time in milliseconds, 0 if not set.
+	Retry int
+}
+
+// sseReader parses a server-sent events stream as specified by the HTML
+// standard: lines may end with CRLF, LF or CR, a leading UTF-8 BOM is ignored,
+// comments are skipped and data fields spanning several lines are joined.
+// Proxies in front of the API are known to reformat streams in all these ways.
+type sseReader struct {
+	scanner *bufio.Scanner
+	lastID  string
+	started bool
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxSSELineSize)
+	scanner.Split(scanSSELines)
+	return &sseReader{scanner: scanner}
+}
+
+// Next returns the next event of the stream. It returns io.EOF at the end of
+// the stream; an event that is not terminated by an empty line is discarded.
+func (r *sseReader) Next() (sseEvent, error) {
+	var (
+		event   sseEvent
+		data    strings.Builder
+		hasData bool
+	)
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if !r.started {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			r.started = true
+		}
+
+		if line == "" {
+			if !hasData {
+				event = sseEvent{}
+				continue
+			}
+			event.ID = r.lastID
+			if event.Event == "" {
+				event.Event = "message"
+			}
+			event.Data = strings.TrimSuffix(data.String(), "\n")
+			return event, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastID = value
+			}
+		case "retry":
+			if strings.Trim(value, "0123456789") != "" {
+				break
+			}
+			if n, err := strconv.Atoi(value); err == nil {
+				event.Retry = n
+			}
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return sseEvent{}, err
+	}
+	return sseEvent{}, io.EOF
+}
+
+// scanSSELines is a bufio.SplitFunc that splits lines terminated by CRLF, LF or CR.
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A CR at the end of the buffer may be the first half of a CRLF.
+		if i+1 == len(data) && !atEOF {
+			return 0, nil, nil
+		}
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
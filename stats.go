@@ -0,0 +1,172 @@
+package gigago
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Error classes used as keys of Stats.Errors.
+const (
+	// ErrorClassTransport counts network and connection failures.
+	ErrorClassTransport = "transport"
+	// ErrorClassAuth counts failures to obtain or refresh an access token.
+	ErrorClassAuth = "auth"
+	// ErrorClassClient counts responses with 4xx status codes.
+	ErrorClassClient = "client"
+	// ErrorClassServer counts responses with 5xx status codes.
+	ErrorClassServer = "server"
+	// ErrorClassDecode counts responses that could not be decoded.
+	ErrorClassDecode = "decode"
+	// ErrorClassLimiter counts requests rejected by the rate limiter.
+	ErrorClassLimiter = "limiter"
+)
+
+// Stats is a snapshot of the client's activity since it was created.
+type Stats struct {
+	// Requests is the number of API calls made, successful or not.
+	// Retries after a token refresh are part of the same call.
+	Requests int64 `json:"requests"`
+
+	// Errors is the number of failed calls by error class (see the ErrorClass constants).
+	Errors map[string]int64 `json:"errors"`
+
+	// PromptTokens, CompletionTokens and TotalTokens sum the token usage reported by the API.
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+
+	// CostCenters is the number of total tokens by cost center (see ContextWithCostCenter).
+	CostCenters map[string]int64 `json:"cost_centers,omitempty"`
+
+	// TokenRefreshes is the number of successful access token refreshes.
+	TokenRefreshes int64 `json:"token_refreshes"`
+
+	// AverageLatency is the average duration of a call.
+	AverageLatency time.Duration `json:"average_latency"`
+}
+
+// clientStats holds the counters behind Stats. It is safe for concurrent use.
+type clientStats struct {
+	requests         atomic.Int64
+	latency          atomic.Int64
+	promptTokens     atomic.Int64
+	completionTokens atomic.Int64
+	totalTokens      atomic.Int64
+	tokenRefreshes   atomic.Int64
+	errors           sync.Map // error class -> *atomic.Int64
+	costCenters      sync.Map // cost center -> *atomic.Int64
+}
+
+func (s *clientStats) recordRequest(latency time.Duration, errorClass string) {
+	s.requests.Add(1)
+	s.latency.Add(int64(latency))
+	if errorClass != "" {
+		counter, _ := s.errors.LoadOrStore(errorClass, new(atomic.Int64))
+		counter.(*atomic.Int64).Add(1)
+	}
+}
+
+func (s *clientStats) recordUsage(costCenter string, usage UsageStats) {
+	s.promptTokens.Add(int64(usage.PromptTokens))
+	s.completionTokens.Add(int64(usage.CompletionTokens))
+	s.totalTokens.Add(int64(usage.TotalTokens))
+	if costCenter != "" {
+		counter, _ := s.costCenters.LoadOrStore(costCenter, new(atomic.Int64))
+		counter.(*atomic.Int64).Add(int64(usage.TotalTokens))
+	}
+}
+
+// Stats returns a snapshot of the client's request, error and token counters.
+func (c *Client) Stats() Stats {
+	s := &c.stats
+	stats := Stats{
+		Requests:         s.requests.Load(),
+		Errors:           make(map[string]int64),
+		PromptTokens:     s.promptTokens.Load(),
+		CompletionTokens: s.completionTokens.Load(),
+		TotalTokens:      s.totalTokens.Load(),
+		TokenRefreshes:   s.tokenRefreshes.Load(),
+	}
+	if stats.Requests > 0 {
+		stats.AverageLatency = time.Duration(s.latency.Load() / stats.Requests)
+	}
+	s.errors.Range(func(key, value any) bool {
+		stats.Errors[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	s.costCenters.Range(func(key, value any) bool {
+		if stats.CostCenters == nil {
+			stats.CostCenters = make(map[string]int64)
+		}
+		stats.CostCenters[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return stats
+}
+
+// WithExpvar provides an Option to publish the client statistics (see Client.Stats)
+// as an expvar variable with the given name, making them available on /debug/vars.
+// NewClient fails if the name is used by another open client or by a variable
+// not published by this package. Close unpublishes the statistics, so a client
+// created later, e.g. after a configuration reload, may reuse the name.
+func WithExpvar(name string) Option {
+	return func(c *Client) {
+		c.expvarName = name
+	}
+}
+
+var (
+	expvarMu sync.Mutex
+	// expvarHandles holds the variables published by WithExpvar by name. The
+	// expvar package cannot remove variables, so they are reused by new clients.
+	expvarHandles = make(map[string]*expvarHandle)
+)
+
+// expvarHandle is the published value of WithExpvar, reporting the statistics of client.
+type expvarHandle struct {
+	client atomic.Pointer[Client]
+}
+
+func (h *expvarHandle) value() any {
+	if c := h.client.Load(); c != nil {
+		return c.Stats()
+	}
+	return nil
+}
+
+// publishExpvar publishes the client statistics if WithExpvar was used.
+func (c *Client) publishExpvar() error {
+	if c.expvarName == "" {
+		return nil
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	h := expvarHandles[c.expvarName]
+	if h == nil {
+		if expvar.Get(c.expvarName) != nil {
+			return fmt.Errorf("expvar %q is already published", c.expvarName)
+		}
+		h = &expvarHandle{}
+		expvar.Publish(c.expvarName, expvar.Func(h.value))
+		expvarHandles[c.expvarName] = h
+	}
+	if !h.client.CompareAndSwap(nil, c) {
+		return fmt.Errorf("expvar %q is already published by another client", c.expvarName)
+	}
+	return nil
+}
+
+// unpublishExpvar detaches the client from its expvar variable, which then reports null.
+func (c *Client) unpublishExpvar() {
+	if c.expvarName == "" {
+		return
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if h := expvarHandles[c.expvarName]; h != nil {
+		h.client.CompareAndSwap(c, nil)
+	}
+}
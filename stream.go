@@ -0,0 +1,461 @@
+package gigago
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"iter"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// errStreamClosed is returned by Recv after the stream was closed.
+var errStreamClosed = errors.New("stream closed")
+
+// CompletionChunk is a piece of a completion streamed by GenerateStream.
+type CompletionChunk struct {
+	// Choices hold the parts of the choices generated since the previous chunk.
+	Choices []ChunkChoice `json:"choices"`
+
+	// Created is the Unix timestamp (seconds) of when the response was created.
+	Created int64 `json:"created"`
+
+	// Model specifies the exact model version used to generate the response.
+	Model string `json:"model"`
+
+	// Object is the type of the API object.
+	Object string `json:"object"`
+
+	// Usage provides statistics on token consumption. It is only set in the
+	// last chunk of the stream.
+	Usage *UsageStats `json:"usage,omitempty"`
+
+	// Seq is the position of the chunk in the stream, starting from 1, set by
+	// Recv. Consecutive chunks have consecutive numbers, so consumers relaying
+	// chunks, e.g. through a message queue, can detect gaps and reordering.
+	// The API does not number chunks itself; Seq counts the chunks returned
+	// by Recv, after ChunkMiddleware.
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// ChunkChoice is the part of a choice carried by a CompletionChunk.
+type ChunkChoice struct {
+	// Delta is the piece of the message generated since the previous chunk.
+	// Role is only set in the first chunk of a choice.
+	Delta ResponseMessage `json:"delta"`
+
+	// Index is the position of this choice in the list, starting from 0.
+	Index int `json:"index"`
+
+	// FinishReason is set in the last chunk of the choice and indicates why
+	// the model stopped generating tokens.
+	FinishReason FinishReason `json:"finish_reason,omitempty"`
+}
+
+// ChunkMiddleware transforms a chunk of a stream before it reaches the consumer
+// (see GenerativeModel.ChunkMiddleware). It may modify the chunk in place or
+// return another one. Returning a nil chunk drops it, and returning an error
+// aborts the stream with that error.
+type ChunkMiddleware func(chunk *CompletionChunk) (*CompletionChunk, error)
+
+// WithStreamIdleTimeout provides an Option to limit the time a stream may stay
+// without receiving any data, including keep-alive comments, before it fails.
+// Defaults to 30 seconds. A zero timeout disables the limit.
+//
+// Streams are not bound by the timeout of WithRequestTimeout, as long
+// generations may take longer than that.
+func WithStreamIdleTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.streamIdleTimeout = timeout
+	}
+}
+
+// Stream is a completion being received from the API as server-sent events.
+// A Stream is not safe for concurrent use; to abort a stream from another
+// goroutine, cancel the context passed to GenerateStream.
+type Stream struct {
+	c          *Client
+	endpoint   string
+	body       []byte
+	model      string
+	costCenter string
+	user       string
+	start      time.Time
+
+	key     *balancedKey
+	res     callResult
+	ctx     context.Context
+	cancel  context.CancelFunc
+	release func()
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+	latency *latencyWatch
+	// middleware is the ChunkMiddleware of the model.
+	middleware []ChunkMiddleware
+
+	resp     io.ReadCloser
+	events   *sseReader
+	finished bool
+	usage    *UsageStats
+	seq      int64
+	// assembled is the completion assembled from the chunks received so far.
+	assembled *CompletionResponse
+	// onComplete, if set, is called with the assembled completion once the stream is complete.
+	onComplete func(*CompletionResponse)
+	// contentHash hashes the content of the first choice as it is received.
+	contentHash hash.Hash
+
+	done bool
+	err  error
+}
+
+// GenerateStream sends a request like Generate, but with streaming enabled, and
+// returns a Stream yielding the generated choices piece by piece as they arrive.
+// The caller must read the stream until Recv returns an error, or Close it.
+//
+// The deltas do not include the text of WithPrefill. Token usage is recorded
+// when the last chunk is received.
+func (g *GenerativeModel) GenerateStream(ctx context.Context, message []Message, opts ...CallOption) (*Stream, error) {
+	callOpts := newCallOptions(opts)
+	payload, err := g.prepare(ctx, message, callOpts)
+	if err != nil {
+		return nil, err
+	}
+	payload.Stream = true
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return g.c.openStream(ctx, g.c.baseURLAI, jsonData, payload.Model, callOpts.latency, slices.Clone(g.ChunkMiddleware))
+}
+
+// openStream sends a streaming request and returns the stream of its response,
+// watching its latency if budget is set and passing its chunks through middleware.
+func (c *Client) openStream(ctx context.Context, endpoint string, body []byte, model string, budget *LatencyBudget, middleware []ChunkMiddleware) (*Stream, error) {
+	s := &Stream{
+		c:          c,
+		endpoint:   endpoint,
+		body:       body,
+		model:      model,
+		costCenter: costCenterFromContext(ctx),
+		user:       userFromContext(ctx),
+		start:      time.Now(),
+		timeout:    c.streamIdleTimeout,
+		latency:    newLatencyWatch(budget),
+		middleware: middleware,
+	}
+	s.res.streamed = true
+	s.assembled = &CompletionResponse{}
+	s.contentHash = sha256.New()
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	release, err := c.queue.acquire(ctx)
+	if err != nil {
+		s.res.errorClass = ErrorClassLimiter
+		return nil, s.finish(fmt.Errorf("request queue: %w", err))
+	}
+	s.release = release
+	if s.timeout > 0 {
+		s.timer = time.AfterFunc(s.timeout, func() {
+			s.idle.Store(true)
+			s.cancel()
+		})
+	}
+	ctx, s.key = c.balancedContext(ctx)
+	s.ctx = ctx
+
+	resp, err := c.send(ctx, http.MethodPost, endpoint, contentTypeJSON, "text/event-stream", body, &s.res)
+	if err != nil {
+		return nil, s.finish(err)
+	}
+	s.res.status = resp.StatusCode
+	s.res.header = resp.Header
+	s.resp = resp.Body
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			s.res.errorClass = ErrorClassTransport
+			return nil, s.finish(fmt.Errorf("failed to read response: %w", err))
+		}
+		s.res.body = respBody
+		s.res.errorClass = statusErrorClass(resp.StatusCode)
+		return nil, s.finish(fmt.Errorf("unexpected %w", newAPIError(resp, respBody)))
+	}
+
+	s.events = newSSEReader(&idleReader{r: resp.Body, s: s})
+	return s, nil
+}
+
+// Recv returns the next chunk of the stream. It returns io.EOF once the
+// stream is complete. Other errors are of type *RequestError.
+func (s *Stream) Recv() (*CompletionChunk, error) {
+	if s.done {
+		return nil, s.err
+	}
+
+	for {
+		event, err := s.events.Next()
+		if err == io.EOF {
+			if !s.finished {
+				s.res.errorClass = ErrorClassTransport
+				return nil, s.finish(fmt.Errorf("stream ended before the completion finished: %w", io.ErrUnexpectedEOF))
+			}
+			return nil, s.finish(io.EOF)
+		}
+		if err != nil {
+			s.res.errorClass = ErrorClassTransport
+			return nil, s.finish(fmt.Errorf("failed to read stream: %w", err))
+		}
+
+		if event.Event == "error" {
+			var fields struct {
+				Status  int    `json:"status"`
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal([]byte(event.Data), &fields)
+			apiErr := &APIError{
+				StatusCode: fields.Status,
+				Code:       fields.Code,
+				Message:    fields.Message,
+				RqUID:      s.res.header.Get("X-Request-ID"),
+				Body:       []byte(event.Data),
+			}
+			s.res.status = apiErr.StatusCode
+			s.res.errorClass = ErrorClassServer
+			if apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 {
+				s.res.errorClass = ErrorClassClient
+			}
+			return nil, s.finish(fmt.Errorf("stream error: %w", apiErr))
+		}
+		if event.Data == "[DONE]" {
+			s.finished = true
+			return nil, s.finish(io.EOF)
+		}
+
+		var chunk CompletionChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			s.res.errorClass = ErrorClassDecode
+			return nil, s.finish(fmt.Errorf("failed to decode chunk: %w", err))
+		}
+		if chunk.Model != "" {
+			s.model = chunk.Model
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				s.finished = true
+			}
+		}
+		s.assemble(&chunk)
+		if chunk.Usage != nil {
+			s.usage = chunk.Usage
+			s.c.stats.recordUsage(s.costCenter, *chunk.Usage)
+			s.c.usage.record(time.Now(), s.costCenter, s.model, *chunk.Usage)
+		}
+		s.latency.chunk()
+		if err := s.throttle(&chunk); err != nil {
+			s.res.errorClass = ErrorClassLimiter
+			return nil, s.finish(fmt.Errorf("stream quota: %w", err))
+		}
+		out := &chunk
+		for _, mw := range s.middleware {
+			if out, err = mw(out); err != nil {
+				return nil, s.finish(fmt.Errorf("chunk middleware: %w", err))
+			}
+			if out == nil {
+				break
+			}
+		}
+		if out == nil {
+			continue
+		}
+		s.seq++
+		out.Seq = s.seq
+		return out, nil
+	}
+}
+
+// All returns an iterator over the remaining chunks of the stream, for use
+// with range. The iteration stops after yielding the first error other than
+// io.EOF. The stream is closed when the iteration ends, including when the
+// loop is left early.
+func (s *Stream) All() iter.Seq2[*CompletionChunk, error] {
+	return func(yield func(*CompletionChunk, error) bool) {
+		defer s.Close()
+		for {
+			chunk, err := s.Recv()
+			if err == io.EOF {
+				return
+			}
+			if !yield(chunk, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Collect reads the rest of the stream and returns the completion assembled
+// from its chunks, as Generate would have returned it, without the text of
+// WithPrefill and before ChunkMiddleware.
+//
+// If the stream fails midway, e.g. because the deadline of the context
+// expired, Collect returns the content received until then, with Truncated
+// set, along with the error. The response is nil if no chunk was received.
+func (s *Stream) Collect() (*CompletionResponse, error) {
+	for {
+		_, err := s.Recv()
+		if err == io.EOF {
+			return s.assembled, nil
+		}
+		if err != nil {
+			if len(s.assembled.Choices) == 0 {
+				return nil, err
+			}
+			s.assembled.Truncated = true
+			return s.assembled, err
+		}
+	}
+}
+
+// ContentHash returns the hex-encoded SHA-256 hash of the content of the first
+// choice of the stream, computed as the chunks are received and before
+// ChunkMiddleware. Comparing it with the ContentHash of the same content
+// obtained otherwise, e.g. relayed by a proxy or stored, detects dropped or
+// altered chunks. It fails if the stream did not complete.
+func (s *Stream) ContentHash() (string, error) {
+	if !s.done || s.err != io.EOF {
+		return "", errors.New("stream is not complete")
+	}
+	return hex.EncodeToString(s.contentHash.Sum(nil)), nil
+}
+
+// ContentHash returns the hex-encoded SHA-256 hash of content, for comparison
+// with Stream.ContentHash.
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Close aborts the stream if it is not complete and releases its resources.
+func (s *Stream) Close() error {
+	if !s.done {
+		s.finish(errStreamClosed)
+	}
+	return nil
+}
+
+// finish releases the resources of the stream, records the call in the
+// statistics and the audit log and returns err as the final error of the
+// stream, wrapped in a *RequestError unless it is io.EOF.
+func (s *Stream) finish(err error) error {
+	if s.done {
+		return s.err
+	}
+	s.done = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.latency.stop()
+	s.cancel()
+	if s.resp != nil {
+		s.resp.Close()
+	}
+	if s.release != nil {
+		s.release()
+	}
+
+	if s.idle.Load() && err != io.EOF && err != errStreamClosed {
+		s.res.errorClass = ErrorClassTransport
+		err = fmt.Errorf("no data received for %s", s.timeout)
+	}
+
+	if err == io.EOF && s.onComplete != nil {
+		s.onComplete(s.assembled)
+	}
+	if s.c.auditLog != nil && s.res.status == http.StatusOK {
+		s.res.body, _ = json.Marshal(s.assembled)
+	}
+
+	elapsed := time.Since(s.start)
+	if s.key != nil {
+		var tokens int
+		if s.usage != nil {
+			tokens = s.usage.TotalTokens
+		}
+		s.c.balancer.report(s.key, time.Now(), s.res.status, s.res.header, tokens)
+	}
+	s.c.stats.recordRequest(elapsed, s.res.errorClass)
+
+	var auditErr error
+	if err != io.EOF {
+		auditErr = err
+	}
+	s.c.audit(s.start, http.MethodPost, s.endpoint, contentTypeJSON, elapsed, s.body, s.res, auditErr)
+
+	if auditErr != nil {
+		reqErr := newRequestError(http.MethodPost, s.endpoint, s.res.attempt, s.start, err)
+		reqErr.class = s.res.errorClass
+		if s.res.status != http.StatusOK {
+			// Failures after the stream was opened are not reported as
+			// failures of a successful response.
+			reqErr.StatusCode = s.res.status
+		}
+		err = reqErr
+	}
+	s.err = err
+	return err
+}
+
+// assemble adds chunk to the completion assembled from the stream.
+func (s *Stream) assemble(chunk *CompletionChunk) {
+	r := s.assembled
+	r.Created = cmp.Or(r.Created, chunk.Created)
+	r.Model = cmp.Or(chunk.Model, r.Model)
+	r.Object = "chat.completion"
+	if chunk.Usage != nil {
+		r.Usage = *chunk.Usage
+	}
+	for _, delta := range chunk.Choices {
+		i := slices.IndexFunc(r.Choices, func(c Choice) bool { return c.Index == delta.Index })
+		if i < 0 {
+			r.Choices = append(r.Choices, Choice{Index: delta.Index})
+			i = len(r.Choices) - 1
+		}
+		if delta.Index == 0 {
+			io.WriteString(s.contentHash, delta.Delta.Content)
+		}
+		choice := &r.Choices[i]
+		choice.Message.Role = cmp.Or(delta.Delta.Role, choice.Message.Role)
+		choice.Message.Content += delta.Delta.Content
+		if delta.Delta.FunctionCall != nil {
+			choice.Message.FunctionCall = delta.Delta.FunctionCall
+		}
+		choice.Message.FunctionsStateID = cmp.Or(delta.Delta.FunctionsStateID, choice.Message.FunctionsStateID)
+		choice.FinishReason = cmp.Or(delta.FinishReason, choice.FinishReason)
+	}
+}
+
+// idleReader resets the idle timer of a stream whenever data is read.
+type idleReader struct {
+	r io.Reader
+	s *Stream
+}
+
+func (r *idleReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 && r.s.timer != nil {
+		r.s.timer.Reset(r.s.timeout)
+	}
+	return n, err
+}
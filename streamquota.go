@@ -0,0 +1,128 @@
+package gigago
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type userKey struct{}
+
+// ContextWithUser returns a copy of ctx that attributes the requests issued with
+// it to the given end user (e.g. a user ID or an API key of a tenant). Streams
+// of the same user share the quota set by WithStreamQuota.
+func ContextWithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// userFromContext returns the user stored in ctx, or "" if none.
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// WithStreamQuota provides an Option to cap the rate at which each user (see
+// ContextWithUser) receives streamed tokens, so that in a deployment shared by
+// many users no single one can drain the account-level quota. Each user may
+// receive burst tokens at once, then tokensPerSecond on average; Stream.Recv
+// holds back chunks that exceed the quota until enough time has passed.
+//
+// Tokens are estimated from the length of the deltas. Streams of all users are
+// throttled independently; streams without a user are not throttled.
+func WithStreamQuota(tokensPerSecond float64, burst int) Option {
+	return func(c *Client) {
+		c.streamQuota = &streamQuota{
+			rate:    tokensPerSecond,
+			burst:   float64(burst),
+			buckets: make(map[string]*quotaBucket),
+		}
+	}
+}
+
+// quotaSweepInterval is how often the buckets of idle users are dropped.
+const quotaSweepInterval = time.Minute
+
+// streamQuota holds a token bucket per user.
+type streamQuota struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*quotaBucket
+	lastSweep time.Time
+}
+
+type quotaBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take consumes n tokens of the user's bucket and returns how long to wait
+// before the tokens may be delivered. The bucket may go into debt, so that
+// concurrent streams of the user wait in turn.
+func (q *streamQuota) take(user string, n int, now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now.Sub(q.lastSweep) >= quotaSweepInterval {
+		q.sweep(now)
+	}
+
+	b, ok := q.buckets[user]
+	if !ok {
+		b = &quotaBucket{tokens: q.burst, last: now}
+		q.buckets[user] = b
+	}
+	if now.After(b.last) {
+		b.tokens = min(q.burst, b.tokens+now.Sub(b.last).Seconds()*q.rate)
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 || q.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / q.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have refilled, as they are the same as new ones.
+func (q *streamQuota) sweep(now time.Time) {
+	for user, b := range q.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*q.rate >= q.burst {
+			delete(q.buckets, user)
+		}
+	}
+	q.lastSweep = now
+}
+
+// throttle waits until the tokens of chunk fit in the quota of the stream's
+// user. The idle timer is paused meanwhile, as the stream is not read.
+func (s *Stream) throttle(chunk *CompletionChunk) error {
+	q := s.c.streamQuota
+	if q == nil || s.user == "" {
+		return nil
+	}
+	var tokens int
+	for _, choice := range chunk.Choices {
+		tokens += estimateTokens(choice.Delta.Content)
+	}
+	delay := q.take(s.user, tokens, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	if s.timer != nil && !s.timer.Stop() {
+		// The idle timeout has fired already.
+		return s.ctx.Err()
+	}
+	wait := time.NewTimer(delay)
+	defer wait.Stop()
+	select {
+	case <-wait.C:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	if s.timer != nil {
+		s.timer.Reset(s.timeout)
+	}
+	return nil
+}
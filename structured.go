@@ -0,0 +1,304 @@
+package gigago
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// defaultRepairAttempts is the default number of repairs made by GenerateInto.
+const defaultRepairAttempts = 2
+
+const structuredOutputInstruction = `Answer with a single JSON value matching this JSON schema, without any other text or Markdown:
+%s`
+
+const repairPrompt = `Your answer is not valid: %v.
+Reply with the corrected JSON only.`
+
+// structuredOutputFunction is the function the model is made to call by
+// StructuredOutputNative.
+const structuredOutputFunction = "answer"
+
+// StructuredOutputStrategy selects how GenerateInto makes the model answer in JSON.
+type StructuredOutputStrategy string
+
+const (
+	// StructuredOutputAuto uses StructuredOutputNative for models known to
+	// support function calling and struct targets, and StructuredOutputPrompt
+	// otherwise, e.g. for fine-tuned models served under custom names.
+	StructuredOutputAuto StructuredOutputStrategy = ""
+	// StructuredOutputNative makes the model call a function whose parameters
+	// are the schema of the target, so that the API returns the answer as the
+	// arguments of the call. The target must be a struct or a map.
+	StructuredOutputNative StructuredOutputStrategy = "native"
+	// StructuredOutputPrompt adds the schema of the target to the system
+	// instruction and extracts the JSON value from the text of the answer.
+	StructuredOutputPrompt StructuredOutputStrategy = "prompt"
+)
+
+// GenerateInto generates an answer in JSON and decodes it into target, which
+// must be a non-nil pointer, e.g. to a struct. The JSON schema of the target
+// type is sent to the model as selected by the StructuredOutput field of the
+// model. If the answer does not decode, the model is shown the error and
+// asked for a corrected answer, up to 2 times by default (see WithRepairAttempts).
+// Before that, answers cut off in the middle of the JSON value are completed
+// by closing the open strings, arrays and objects.
+//
+// The schema is derived from the json tags of struct fields: fields without
+// omitempty are required, and a description tag documents a field to the
+// model. The last response is returned along with the error if all attempts
+// fail.
+func (g *GenerativeModel) GenerateInto(ctx context.Context, messages []Message, target any, opts ...CallOption) (*CompletionResponse, error) {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil, fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+
+	schema := jsonSchema(v.Type().Elem(), nil)
+	native, err := g.nativeStructuredOutput(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	model := *g
+	if native {
+		model.Functions = []Function{{
+			Name:        structuredOutputFunction,
+			Description: "Returns the answer to the user.",
+			Parameters:  FunctionParameters{Type: schema.Type, Properties: schema.Properties, Required: schema.Required},
+		}}
+		model.FunctionCall = structuredOutputFunction
+	} else {
+		schemaJSON, err := json.Marshal(schema)
+		if err != nil {
+			return nil, err
+		}
+		model = *g.withSystemInstruction(strings.TrimSpace(g.SystemInstruction + "\n\n" + fmt.Sprintf(structuredOutputInstruction, schemaJSON)))
+		model.Functions = nil
+		model.FunctionCall = ""
+	}
+
+	repairs := defaultRepairAttempts
+	if n := newCallOptions(opts).repairAttempts; n != nil {
+		repairs = max(*n, 0)
+	}
+
+	conversation := messages
+	for attempt := 0; ; attempt++ {
+		resp, err := model.Generate(ctx, conversation, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return resp, errors.New("response has no choices")
+		}
+
+		answer := resp.Choices[0].Message
+		var repair []Message
+		switch {
+		case !native:
+			err = decodeJSONAnswer(answer.Content, target)
+			repair = []Message{
+				{Role: RoleAssistant, Content: answer.Content},
+				{Role: RoleUser, Content: fmt.Sprintf(repairPrompt, err)},
+			}
+		case answer.FunctionCall == nil:
+			err = fmt.Errorf("the model did not call the %s function", structuredOutputFunction)
+			repair = []Message{
+				{Role: RoleAssistant, Content: answer.Content},
+				{Role: RoleUser, Content: fmt.Sprintf(repairPrompt, err)},
+			}
+		default:
+			err = decodeJSONAnswer(string(answer.FunctionCall.Arguments), target)
+			result, _ := json.Marshal(map[string]string{"error": fmt.Sprint(err)})
+			repair = []Message{
+				answer.Message(),
+				{Role: RoleFunction, Name: structuredOutputFunction, Content: string(result), FunctionsStateID: answer.FunctionsStateID},
+			}
+		}
+		if err == nil {
+			return resp, nil
+		}
+		if attempt == repairs {
+			return resp, fmt.Errorf("failed to decode answer after %d attempts: %w", attempt+1, err)
+		}
+		conversation = append(conversation[:len(conversation):len(conversation)], repair...)
+	}
+}
+
+// nativeStructuredOutput reports whether GenerateInto uses StructuredOutputNative
+// for a target with the given schema.
+func (g *GenerativeModel) nativeStructuredOutput(schema *Property) (bool, error) {
+	switch g.StructuredOutput {
+	case StructuredOutputNative:
+		if schema.Type != "object" {
+			return false, fmt.Errorf("structured output strategy %q requires a struct or map target, got a schema of type %q", StructuredOutputNative, schema.Type)
+		}
+		return true, nil
+	case StructuredOutputPrompt:
+		return false, nil
+	case StructuredOutputAuto:
+		// Models known to the SDK all support function calling.
+		return schema.Type == "object" && contextWindowSize(g.fullName) > 0, nil
+	default:
+		return false, fmt.Errorf("unknown structured output strategy %q", g.StructuredOutput)
+	}
+}
+
+// decodeJSONAnswer decodes the JSON value of an answer into target. Markdown
+// code fences and text around the value are ignored, and a value cut off
+// before its end is completed.
+func decodeJSONAnswer(content string, target any) error {
+	text := balanceJSON(strings.TrimSpace(content))
+	if text == "" {
+		return errors.New("answer is empty")
+	}
+	return json.Unmarshal([]byte(text), target)
+}
+
+// balanceJSON returns the first JSON array or object of text, or text itself
+// if it has none. If the value is not closed, e.g. because the answer was
+// truncated, the open string, arrays and objects are closed.
+func balanceJSON(text string) string {
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+
+	var open []byte
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch ch {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '{':
+			open = append(open, '}')
+		case ch == '[':
+			open = append(open, ']')
+		case ch == '}' || ch == ']':
+			if ch != open[len(open)-1] {
+				// Not JSON; let the decoder report the error.
+				return text[start:]
+			}
+			open = open[:len(open)-1]
+			if len(open) == 0 {
+				return text[start : i+1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(text[start:])
+	if inString {
+		if escaped {
+			b.WriteByte('\\')
+		}
+		b.WriteByte('"')
+	}
+	repaired := strings.TrimRight(b.String(), " \t\r\n")
+	switch {
+	case strings.HasSuffix(repaired, ","):
+		repaired = strings.TrimSuffix(repaired, ",")
+	case strings.HasSuffix(repaired, ":"):
+		repaired += "null"
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		repaired += string(open[i])
+	}
+	return repaired
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+// jsonSchema returns the JSON schema of values of type t as encoded by
+// encoding/json. seen holds the struct types being described, to stop at
+// recursive types.
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) *Property {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &Property{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Property{Type: "string"}
+	case reflect.Bool:
+		return &Property{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Property{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Property{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings.
+			return &Property{Type: "string"}
+		}
+		return &Property{Type: "array", Items: jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Property{Type: "object"}
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		p := &Property{Type: "object", Properties: make(map[string]*Property)}
+		addStructFields(p, t, seen)
+		return p
+	}
+	return &Property{Type: "object"}
+}
+
+// addStructFields adds the fields of struct type t to the object schema p,
+// including the fields of embedded structs.
+func addStructFields(p *Property, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addStructFields(p, ft, seen)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := jsonSchema(f.Type, seen)
+		field.Description = f.Tag.Get("description")
+		p.Properties[name] = field
+		if !strings.Contains(","+options+",", ",omitempty,") && !strings.Contains(","+options+",", ",omitzero,") {
+			p.Required = append(p.Required, name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package gigago
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ErrSystemInstructionLocked is returned by Generate when a model with a locked
+// system instruction is asked to send a different one.
+var ErrSystemInstructionLocked = errors.New("system instruction is locked")
+
+// LockSystemInstruction pins the current system instruction of the model.
+// After that, Generate fails with ErrSystemInstructionLocked if SystemInstruction
+// has been changed or the conversation contains system messages, so neither app
+// code rebuilding the history nor injected content (e.g. tool outputs) can
+// replace or drop the instruction. Copies of the model share the lock; helpers
+// that send their own instruction, such as GenerateWithPlanning and WithPersona,
+// lock it in place of the pinned one.
+func (g *GenerativeModel) LockSystemInstruction() {
+	sum := sha256.Sum256([]byte(g.SystemInstruction))
+	g.systemLock = &sum
+}
+
+// checkSystemLock verifies the messages against the locked system instruction, if any.
+func (g *GenerativeModel) checkSystemLock(messages []Message) error {
+	if g.systemLock == nil {
+		return nil
+	}
+	if sha256.Sum256([]byte(g.SystemInstruction)) != *g.systemLock {
+		return fmt.Errorf("%w: SystemInstruction was changed", ErrSystemInstructionLocked)
+	}
+	for i, m := range messages {
+		if m.Role == RoleSystem {
+			return fmt.Errorf("%w: message %d has role system", ErrSystemInstructionLocked, i)
+		}
+	}
+	return nil
+}
+
+// withSystemInstruction returns a copy of the model with another system
+// instruction, for the internal requests of helpers such as
+// GenerateWithPlanning. If the model is locked, the copy is locked to the new
+// instruction, so it still rejects system messages; if the instruction of the
+// model was changed after locking, the copy keeps the lock and fails as well.
+func (g *GenerativeModel) withSystemInstruction(instruction string) *GenerativeModel {
+	model := *g
+	model.SystemInstruction = instruction
+	if g.systemLock != nil && sha256.Sum256([]byte(g.SystemInstruction)) == *g.systemLock {
+		model.LockSystemInstruction()
+	}
+	return &model
+}
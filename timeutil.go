@@ -0,0 +1,22 @@
+package gigago
+
+import "time"
+
+// unixMillisThreshold separates Unix timestamps in seconds from those in milliseconds:
+// a timestamp in seconds reaches it only in the year 33658, one in milliseconds
+// has exceeded it since 2001.
+const unixMillisThreshold = 1e12
+
+// unixTime converts a Unix timestamp returned by the API to time.Time.
+// The API uses seconds in some places and milliseconds in others, so the unit
+// is detected from the magnitude of the value. Zero converts to the zero Time.
+func unixTime(v int64) time.Time {
+	switch {
+	case v == 0:
+		return time.Time{}
+	case v >= unixMillisThreshold || v <= -unixMillisThreshold:
+		return time.UnixMilli(v)
+	default:
+		return time.Unix(v, 0)
+	}
+}
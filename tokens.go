@@ -0,0 +1,55 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"unicode/utf8"
+)
+
+// TokenCount is the number of tokens in a text as counted by the API.
+type TokenCount struct {
+	// Tokens is the number of tokens in the text.
+	Tokens int `json:"tokens"`
+
+	// Characters is the number of characters in the text.
+	Characters int `json:"characters"`
+}
+
+type tokensCountRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// CountTokens returns the number of tokens in each of the texts for the given model,
+// using the /tokens/count endpoint. Counting tokens is free of charge.
+func (c *Client) CountTokens(ctx context.Context, model string, texts ...string) ([]TokenCount, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no texts to count")
+	}
+
+	body, err := json.Marshal(tokensCountRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []TokenCount
+	if err := c.doJSON(ctx, http.MethodPost, c.apiURL("/tokens/count"), body, &counts); err != nil {
+		return nil, err
+	}
+	if len(counts) != len(texts) {
+		return nil, fmt.Errorf("expected %d token counts, got %d", len(texts), len(counts))
+	}
+	return counts, nil
+}
+
+// charsPerToken is the average number of characters in a GigaChat token,
+// used to estimate token counts without calling the API.
+const charsPerToken = 3.5
+
+// estimateTokens returns a rough estimate of the number of tokens in text.
+func estimateTokens(text string) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / charsPerToken))
+}
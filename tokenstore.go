@@ -0,0 +1,142 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Token is an access token kept in a TokenStore.
+type Token struct {
+	// AccessToken is the token sent with API requests.
+	AccessToken string `json:"access_token"`
+
+	// ExpiresAt is the expiration time of the token.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenStore keeps the access token of a client outside of it, so that
+// several processes using the same authorization key and scope share one
+// token instead of each requesting its own from the OAuth endpoint.
+// Implementations may be backed by e.g. Redis or a file (see NewFileTokenStore)
+// and must be safe for concurrent use.
+type TokenStore interface {
+	// Load returns the stored token, or nil if there is none.
+	Load(ctx context.Context) (*Token, error)
+
+	// Save stores token, replacing the previous one.
+	Save(ctx context.Context, token *Token) error
+}
+
+// WithTokenStore provides an Option to share the access token through store.
+// Before requesting a token, the client loads the stored one and uses it if
+// it does not expire within 15 minutes and is not the token being replaced;
+// tokens obtained from the OAuth endpoint are saved to the store. Failures of
+// the store are logged and fall back to the OAuth endpoint.
+//
+// The store must only be shared by clients with the same authorization key
+// and scope. Tokens of ContextWithCredentials and WithKeyBalancing are not stored.
+func WithTokenStore(store TokenStore) Option {
+	return func(c *Client) {
+		c.tokenStore = store
+	}
+}
+
+// obtainToken returns a new access token to replace stale ("" if there is
+// none): a token saved to the token store by another process, if usable,
+// or a token from the token provider or the OAuth endpoint.
+func (c *Client) obtainToken(ctx context.Context, stale string) (*tokenResponse, error) {
+	if c.tokenStore != nil {
+		stored, err := c.tokenStore.Load(ctx)
+		if err != nil {
+			c.logf("failed to load token from store: %v", err)
+		} else if stored != nil && stored.AccessToken != stale && c.isValid(stored.ExpiresAt.UnixMilli(), time.Now()) {
+			return &tokenResponse{AccessToken: stored.AccessToken, ExpiresAt: stored.ExpiresAt.UnixMilli()}, nil
+		}
+	}
+
+	var (
+		token *tokenResponse
+		err   error
+	)
+	switch {
+	case c.tokenProvider != nil:
+		token, err = c.providerToken(ctx)
+	case c.oauthCreateFunc != nil:
+		token, err = c.oauthCreateFunc(ctx)
+	default:
+		token, err = c.oauthCreate(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errors.New("oauth returned no token")
+	}
+
+	if c.tokenStore != nil {
+		stored := &Token{AccessToken: token.AccessToken, ExpiresAt: unixTime(token.ExpiresAt)}
+		if err := c.tokenStore.Save(ctx, stored); err != nil {
+			c.logf("failed to save token to store: %v", err)
+		}
+	}
+	return token, nil
+}
+
+// FileTokenStore is a TokenStore keeping the token in a file, for processes
+// sharing a file system.
+type FileTokenStore struct {
+	path string
+}
+
+// NewFileTokenStore returns a TokenStore keeping the token in the file at path.
+// The file is created with permissions 0600 when the first token is saved.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+// Load reads the token from the file. It returns nil if the file does not exist.
+func (s *FileTokenStore) Load(ctx context.Context) (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token file %s: %w", s.path, err)
+	}
+	return &token, nil
+}
+
+// Save writes the token to the file. The file is replaced atomically, so
+// concurrent readers never see a partial token.
+func (s *FileTokenStore) Save(ctx context.Context, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,85 @@
+package gigago
+
+import "unicode/utf8"
+
+// TranscriptOpKind is the kind of a TranscriptOp.
+type TranscriptOpKind int
+
+const (
+	// TranscriptAppend appends Text to the end of the rendered transcript.
+	TranscriptAppend TranscriptOpKind = iota
+
+	// TranscriptReplace truncates the rendered transcript to Offset characters
+	// and appends Text. It is produced when earlier text was revised.
+	TranscriptReplace
+)
+
+// TranscriptOp is a minimal edit that brings a rendered transcript up to date.
+type TranscriptOp struct {
+	Kind TranscriptOpKind
+
+	// Offset is the position, in characters (runes), where the edit starts.
+	// For TranscriptAppend it equals the length of the transcript before the edit.
+	Offset int
+
+	// Text is the text written at Offset.
+	Text string
+}
+
+// TranscriptDiffer converts successive snapshots of cumulative content (e.g. the
+// text accumulated from a stream) into minimal edits, suited for "typewriter"
+// rendering in terminals or web UIs without redrawing the whole text.
+// The zero value is ready to use. A TranscriptDiffer is not safe for concurrent use.
+type TranscriptDiffer struct {
+	current string
+	length  int
+}
+
+// Update records the new cumulative content and returns the edit that turns the
+// previous content into it, or nil if nothing changed.
+func (d *TranscriptDiffer) Update(content string) []TranscriptOp {
+	if content == d.current {
+		return nil
+	}
+
+	// Find the longest common prefix, on rune boundaries.
+	prefixBytes, prefixRunes := 0, 0
+	for prefixBytes < len(d.current) && prefixBytes < len(content) {
+		r1, n1 := utf8.DecodeRuneInString(d.current[prefixBytes:])
+		r2, n2 := utf8.DecodeRuneInString(content[prefixBytes:])
+		if r1 != r2 || n1 != n2 {
+			break
+		}
+		prefixBytes += n1
+		prefixRunes++
+	}
+
+	op := TranscriptOp{Kind: TranscriptAppend, Offset: prefixRunes, Text: content[prefixBytes:]}
+	if prefixBytes < len(d.current) {
+		op.Kind = TranscriptReplace
+	}
+
+	d.current = content
+	d.length = utf8.RuneCountInString(content)
+	return []TranscriptOp{op}
+}
+
+// Text returns the current cumulative content.
+func (d *TranscriptDiffer) Text() string {
+	return d.current
+}
+
+// Len returns the length of the current content in characters (runes).
+func (d *TranscriptDiffer) Len() int {
+	return d.length
+}
+
+// ApplyTranscriptOp applies op to text and returns the result. It is the
+// reference implementation of the semantics of TranscriptOp.
+func ApplyTranscriptOp(text string, op TranscriptOp) string {
+	runes := []rune(text)
+	if op.Offset < len(runes) {
+		runes = runes[:op.Offset]
+	}
+	return string(runes) + op.Text
+}
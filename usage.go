@@ -0,0 +1,209 @@
+package gigago
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// usageResolution is the granularity of the usage history kept by the client.
+	usageResolution = time.Minute
+	// defaultUsageRetention is how long the usage history is kept by default,
+	// enough for a monthly billing period.
+	defaultUsageRetention = 35 * 24 * time.Hour
+)
+
+// WithUsageRetention provides an Option to keep the usage history reported by
+// UsageSince for d instead of 35 days. Older usage is discarded as new requests
+// are recorded, which bounds the memory used by long-running processes.
+func WithUsageRetention(d time.Duration) Option {
+	return func(c *Client) {
+		if d <= 0 {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("WithUsageRetention: duration must be positive, got %v", d))
+			return
+		}
+		c.usage.retention = d
+	}
+}
+
+// WithTokenPrices provides an Option to compute the cost of the usage reported
+// by UsageSince and ResetUsage. prices maps model names without version
+// suffixes (e.g. "GigaChat-Pro") to the price of 1000 tokens in the currency
+// of the account; models without a price cost nothing.
+func WithTokenPrices(prices map[string]float64) Option {
+	return func(c *Client) {
+		c.usage.prices = maps.Clone(prices)
+	}
+}
+
+// ModelUsage holds the token consumption of a model.
+type ModelUsage struct {
+	Requests              int64 `json:"requests"`
+	PromptTokens          int64 `json:"prompt_tokens"`
+	CompletionTokens      int64 `json:"completion_tokens"`
+	PrecachedPromptTokens int64 `json:"precached_prompt_tokens"`
+	TotalTokens           int64 `json:"total_tokens"`
+
+	// Cost is the price of the tokens set with WithTokenPrices.
+	Cost float64 `json:"cost,omitempty"`
+}
+
+func (u *ModelUsage) add(o ModelUsage) {
+	u.Requests += o.Requests
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.PrecachedPromptTokens += o.PrecachedPromptTokens
+	u.TotalTokens += o.TotalTokens
+	u.Cost += o.Cost
+}
+
+// Usage is a snapshot of the token consumption of a client by model and by
+// cost center.
+type Usage struct {
+	// Since is the start of the period covered by the snapshot.
+	Since time.Time `json:"since"`
+
+	// Until is the moment the snapshot was taken.
+	Until time.Time `json:"until"`
+
+	// Models maps model names (without version suffixes) to their usage.
+	Models map[string]ModelUsage `json:"models"`
+
+	// CostCenters maps cost centers (see ContextWithCostCenter) to their usage
+	// summed over all models. Untagged requests are not included.
+	CostCenters map[string]ModelUsage `json:"cost_centers,omitempty"`
+}
+
+// Total returns the usage summed over all models.
+func (u Usage) Total() ModelUsage {
+	var total ModelUsage
+	for _, m := range u.Models {
+		total.add(m)
+	}
+	return total
+}
+
+// usageKey identifies the usage of a model by a cost center within a bucket.
+type usageKey struct {
+	model      string
+	costCenter string
+}
+
+// usageTracker keeps the usage history of a client in buckets of usageResolution.
+// It is safe for concurrent use.
+type usageTracker struct {
+	mu        sync.Mutex
+	since     time.Time
+	buckets   map[time.Time]map[usageKey]*ModelUsage
+	retention time.Duration
+	prices    map[string]float64
+}
+
+func (t *usageTracker) record(now time.Time, costCenter, model string, usage UsageStats) {
+	model, _, _ = strings.Cut(model, ":")
+	key := usageKey{model: model, costCenter: costCenter}
+	bucket := now.Truncate(usageResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.buckets == nil {
+		t.buckets = make(map[time.Time]map[usageKey]*ModelUsage)
+	}
+	if t.since.IsZero() {
+		t.since = now
+	}
+	models, ok := t.buckets[bucket]
+	if !ok {
+		t.prune(bucket)
+		models = make(map[usageKey]*ModelUsage)
+		t.buckets[bucket] = models
+	}
+	m, ok := models[key]
+	if !ok {
+		m = &ModelUsage{}
+		models[key] = m
+	}
+	m.add(ModelUsage{
+		Requests:              1,
+		PromptTokens:          int64(usage.PromptTokens),
+		CompletionTokens:      int64(usage.CompletionTokens),
+		PrecachedPromptTokens: int64(usage.PrecachedPromptTokens),
+		TotalTokens:           int64(usage.TotalTokens),
+		Cost:                  float64(usage.TotalTokens) * t.prices[model] / 1000,
+	})
+}
+
+// prune discards the buckets older than the retention window ending at now.
+// It is called once per bucket. The caller must hold t.mu.
+func (t *usageTracker) prune(now time.Time) {
+	cutoff := now.Add(-cmp.Or(t.retention, defaultUsageRetention))
+	for bucket := range t.buckets {
+		if bucket.Before(cutoff) {
+			delete(t.buckets, bucket)
+		}
+	}
+}
+
+// snapshot sums the buckets starting at or after since. The caller must hold t.mu.
+func (t *usageTracker) snapshot(since, now time.Time) Usage {
+	usage := Usage{Since: since, Until: now, Models: make(map[string]ModelUsage)}
+	from := since.Truncate(usageResolution)
+	for bucket, models := range t.buckets {
+		if bucket.Before(from) {
+			continue
+		}
+		for key, m := range models {
+			total := usage.Models[key.model]
+			total.add(*m)
+			usage.Models[key.model] = total
+
+			if key.costCenter == "" {
+				continue
+			}
+			if usage.CostCenters == nil {
+				usage.CostCenters = make(map[string]ModelUsage)
+			}
+			total = usage.CostCenters[key.costCenter]
+			total.add(*m)
+			usage.CostCenters[key.costCenter] = total
+		}
+	}
+	return usage
+}
+
+// UsageSince returns the token consumption recorded since t, by model.
+// Usage is kept with a resolution of one minute, so requests made in the minute
+// containing t are included. Usage is retained for 35 days, or the duration set
+// with WithUsageRetention, or until ResetUsage is called.
+func (c *Client) UsageSince(t time.Time) Usage {
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+	return c.usage.snapshot(t, time.Now())
+}
+
+// ResetUsage atomically returns the token consumption recorded since the
+// previous reset (or since the client was created) and clears it.
+// It is intended for per-billing-period reporting.
+// It also resets the usage counted against the quotas of WithKeyBalancing.
+func (c *Client) ResetUsage() Usage {
+	now := time.Now()
+
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+
+	usage := c.usage.snapshot(time.Time{}, now)
+	if !c.usage.since.IsZero() {
+		usage.Since = c.usage.since
+	}
+	c.usage.buckets = nil
+	c.usage.since = now
+	if c.balancer != nil {
+		c.balancer.resetUsage()
+	}
+	return usage
+}
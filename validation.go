@@ -0,0 +1,52 @@
+package gigago
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEmptyResponse is matched (via errors.Is) by every EmptyResponseError.
+var ErrEmptyResponse = errors.New("empty response")
+
+// EmptyResponseError is returned, with WithResponseValidation, for a response
+// that is valid JSON but carries no answer.
+type EmptyResponseError struct {
+	// Reason describes what is missing, e.g. "no choices".
+	Reason string
+
+	// Body is the raw response body.
+	Body []byte
+}
+
+// Error implements the error interface.
+func (e *EmptyResponseError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrEmptyResponse, e.Reason)
+}
+
+// Is reports whether target is ErrEmptyResponse.
+func (e *EmptyResponseError) Is(target error) bool {
+	return target == ErrEmptyResponse
+}
+
+// WithResponseValidation provides an Option to make Generate fail with an
+// *EmptyResponseError instead of returning a completion without choices or
+// with a choice that has neither content nor a function call, so callers can
+// index Choices[0] safely.
+func WithResponseValidation() Option {
+	return func(c *Client) {
+		c.responseValidation = true
+	}
+}
+
+// validateCompletion checks that a completion carries an answer.
+func validateCompletion(resp *CompletionResponse, body []byte) error {
+	if len(resp.Choices) == 0 {
+		return &EmptyResponseError{Reason: "no choices", Body: body}
+	}
+	for _, choice := range resp.Choices {
+		if choice.Message.Content == "" && choice.Message.FunctionCall == nil {
+			return &EmptyResponseError{Reason: fmt.Sprintf("choice %d has no content", choice.Index), Body: body}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package gigago
+
+import "context"
+
+// VariantResult is the result of a single parameter set of GenerateVariants.
+type VariantResult struct {
+	// Label describes the parameter set, e.g. "temperature=0.7 top_p=0.9".
+	Label string
+
+	// Config is the parameter set.
+	Config GenerationConfig
+
+	// Response is the completion, nil if Err is set.
+	Response *CompletionResponse
+
+	// Err is the error of the request.
+	Err error
+}
+
+// GenerateVariants runs the same messages with each of the parameter sets
+// concurrently and returns the labeled results in the order of configs.
+// It is meant for prompt experiments, such as tuning temperature and top_p.
+// Parameters left nil in a config are taken from the model.
+func (g *GenerativeModel) GenerateVariants(ctx context.Context, messages []Message, configs []GenerationConfig) []VariantResult {
+	items := make([]Prompt, len(configs))
+	for i, cfg := range configs {
+		items[i] = Prompt{Messages: messages, Config: cfg}
+	}
+
+	results := make([]VariantResult, len(configs))
+	for i, r := range g.MapGenerate(ctx, items, nil, MapOptions{Concurrency: len(configs)}) {
+		results[i] = VariantResult{
+			Label:    configs[i].String(),
+			Config:   configs[i],
+			Response: r.Response,
+			Err:      r.Err,
+		}
+	}
+	return results
+}
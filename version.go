@@ -0,0 +1,139 @@
+package gigago
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the SDK.
+const Version = "0.1.0"
+
+// sdkAPIFeatures lists the API features the SDK uses, as named in compatibility manifests.
+var sdkAPIFeatures = []string{"chat_completions", "models", "tokens_count", "files"}
+
+// CompatibilityManifest describes the SDK versions and API features supported by
+// a gateway. It is published as JSON at a URL passed to WithCompatibilityCheck.
+type CompatibilityManifest struct {
+	// MinSDKVersion is the minimum SDK version the gateway supports.
+	MinSDKVersion string `json:"min_sdk_version"`
+
+	// APIVersions lists the API versions the gateway serves (e.g. "v1").
+	APIVersions []string `json:"api_versions"`
+
+	// Features lists the API features the gateway provides.
+	Features []string `json:"features"`
+
+	// Removed lists the API features the gateway no longer provides.
+	Removed []string `json:"removed"`
+}
+
+// WithCompatibilityCheck provides an Option to check, when the client is created,
+// the compatibility manifest (see CompatibilityManifest) published at url.
+// Incompatibilities and failures to fetch the manifest are logged as warnings
+// and do not make NewClient fail.
+func WithCompatibilityCheck(url string) Option {
+	return func(c *Client) {
+		c.compatibilityURL = url
+	}
+}
+
+// CheckCompatibility fetches the compatibility manifest published at url and
+// returns a warning for each incompatibility with the client: an outdated SDK
+// version, an unsupported API version, and API features that the SDK does not
+// know about or depends on but that were removed.
+func (c *Client) CheckCompatibility(ctx context.Context, url string) ([]string, error) {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch compatibility manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected %w", newAPIError(resp, body))
+	}
+
+	var manifest CompatibilityManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode compatibility manifest: %w", err)
+	}
+	return c.compatibilityWarnings(&manifest), nil
+}
+
+func (c *Client) compatibilityWarnings(m *CompatibilityManifest) []string {
+	var warnings []string
+	if m.MinSDKVersion != "" && compareVersions(Version, m.MinSDKVersion) < 0 {
+		warnings = append(warnings, fmt.Sprintf("SDK version %s is older than the minimum supported version %s", Version, m.MinSDKVersion))
+	}
+	if len(m.APIVersions) > 0 && !slices.Contains(m.APIVersions, c.apiVersion) {
+		warnings = append(warnings, fmt.Sprintf("API version %s is not supported, supported versions: %s", c.apiVersion, strings.Join(m.APIVersions, ", ")))
+	}
+	for _, f := range m.Features {
+		if !slices.Contains(sdkAPIFeatures, f) {
+			warnings = append(warnings, fmt.Sprintf("API feature %q is not known to the SDK", f))
+		}
+	}
+	for _, f := range m.Removed {
+		if slices.Contains(sdkAPIFeatures, f) {
+			warnings = append(warnings, fmt.Sprintf("API feature %q used by the SDK was removed", f))
+		}
+	}
+	return warnings
+}
+
+// checkCompatibility logs the result of the check requested with WithCompatibilityCheck.
+func (c *Client) checkCompatibility(ctx context.Context) {
+	if c.compatibilityURL == "" {
+		return
+	}
+	warnings, err := c.CheckCompatibility(ctx, c.compatibilityURL)
+	if err != nil {
+		c.logf("compatibility check failed: %v", err)
+		return
+	}
+	for _, w := range warnings {
+		c.logf("compatibility: %s", w)
+	}
+}
+
+// compareVersions compares two dotted version numbers ("1.2.3") and returns
+// -1, 0 or 1. A "v" prefix and pre-release suffixes are ignored.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := range max(len(pa), len(pb)) {
+		var na, nb int
+		if i < len(pa) {
+			na = versionNumber(pa[i])
+		}
+		if i < len(pb) {
+			nb = versionNumber(pb[i])
+		}
+		if c := cmp.Compare(na, nb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func versionNumber(s string) int {
+	s, _, _ = strings.Cut(s, "-")
+	n, _ := strconv.Atoi(s)
+	return n
+}
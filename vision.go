@@ -0,0 +1,64 @@
+package gigago
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// imageExtensions maps the image types accepted by the API to file extensions.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/tiff": ".tiff",
+	"image/bmp":  ".bmp",
+}
+
+// GenerateWithImage uploads the image read from r, sends prompt along with it
+// as a user message and returns the model's answer, e.g. a description of the
+// image. The image type is detected from its content and must be JPEG, PNG,
+// TIFF or BMP. The model must support image understanding (e.g. GigaChat-Pro).
+//
+// The uploaded file is kept; its ID is in the Attachments of the message, which
+// can be built by hand to reuse the image in later requests. With
+// WithKeyBalancing, the upload and the request are made with the same key, as
+// files are private to the account that uploaded them.
+func (g *GenerativeModel) GenerateWithImage(ctx context.Context, prompt string, r io.Reader, opts ...CallOption) (*CompletionResponse, error) {
+	data, err := io.ReadAll(io.LimitReader(r, defaultUploadMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) > defaultUploadMaxBytes {
+		return nil, fmt.Errorf("image is too large: limit is %d bytes", defaultUploadMaxBytes)
+	}
+
+	contentType := imageType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type %q", contentType)
+	}
+
+	// The file is only visible to the account that uploaded it.
+	ctx = g.c.pinnedContext(ctx)
+	file, err := g.c.UploadFile(ctx, "image"+ext, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	message := Message{Role: RoleUser, Content: strings.TrimSpace(prompt), Attachments: []string{file.ID}}
+	return g.Generate(ctx, []Message{message}, opts...)
+}
+
+// imageType returns the content type of an image, detected from its content.
+func imageType(data []byte) string {
+	// TIFF is not among the types detected by http.DetectContentType.
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return contentType
+}